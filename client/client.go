@@ -2,18 +2,105 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Guazi-inc/etcd-tool/utils"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 )
 
+// DefaultMaxTxnOps is the default --max-txn-ops of etcd server
+const DefaultMaxTxnOps = 128
+
+// MaxTxnBytes keeps the ops of a transaction below the 1.5MiB default
+// --max-request-bytes of etcd server
+const MaxTxnBytes = 1 << 20
+
+// DefaultPageSize is the number of keys Range reads per request by default
+const DefaultPageSize = 1000
+
+var ErrTxnConflict = errors.New("keys were modified concurrently")
+
+// ErrKeyNotFound is returned by GetKV when the key doesn't exist
+var ErrKeyNotFound = errors.New("key not found")
+
 type Client struct {
 	*clientv3.Client
+	requestTimeout time.Duration
+}
+
+// ConnOptions tunes the connection, the zero fields of a dsn take the
+// DefaultConnOptions
+type ConnOptions struct {
+	DialTimeout    time.Duration
+	RequestTimeout time.Duration
+	// Retries is the number of dial attempts
+	Retries       int
+	RetryInterval time.Duration
+	// Keepalive is the interval of the keepalive pings, 0 disables them
+	Keepalive       time.Duration
+	MaxCallRecvSize int
+}
+
+// DefaultConnOptions are used for the options a dsn doesn't set, the CLI
+// overrides them with its root flags
+var DefaultConnOptions = ConnOptions{
+	DialTimeout:    3 * time.Second,
+	RequestTimeout: time.Second,
+	Retries:        3,
+	RetryInterval:  3 * time.Second,
+}
+
+// withDefaults fills the zero options from DefaultConnOptions
+func (o ConnOptions) withDefaults() ConnOptions {
+	d := DefaultConnOptions
+	if o.DialTimeout == 0 {
+		o.DialTimeout = d.DialTimeout
+	}
+	if o.RequestTimeout == 0 {
+		o.RequestTimeout = d.RequestTimeout
+	}
+	if o.Retries == 0 {
+		o.Retries = d.Retries
+	}
+	if o.RetryInterval == 0 {
+		o.RetryInterval = d.RetryInterval
+	}
+	if o.Keepalive == 0 {
+		o.Keepalive = d.Keepalive
+	}
+	if o.MaxCallRecvSize == 0 {
+		o.MaxCallRecvSize = d.MaxCallRecvSize
+	}
+	return o
+}
+
+// KV is a key with its metadata
+type KV struct {
+	Key            string
+	Value          string
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+	Lease          int64
+}
+
+// KVResult is a key read by GetKV, Exists tells a missing key from an empty
+// value
+type KVResult struct {
+	KV
+	Exists bool
 }
 
 type Config struct {
@@ -21,44 +108,190 @@ type Config struct {
 	Username string
 	Password string
 	Path     string
+
+	// TLS is set by the etcds:// scheme or by any of the tls parameters
+	TLS                bool
+	CACert             string
+	Cert               string
+	Key                string
+	InsecureSkipVerify bool
+	ServerName         string
+
+	ConnOptions
 }
 
-// username:password@addr1,addr2/path
+// ParseDSN parses [etcd://|etcds://][username:password@]addr1,addr2[/path][?params],
+// it returns nil when the dsn is invalid, see parseDSN for the error.
+//
+// params are the tls settings: cacert, cert and key are pem files,
+// insecure_skip_verify is a bool and server_name overrides the name checked
+// against the server certificate. And the ConnOptions: dial_timeout,
+// request_timeout, retry_interval and keepalive are durations like 5s or
+// seconds, retries and max_call_recv_size (in bytes) are ints.
 func ParseDSN(dsn string) *Config {
-	rg, err := regexp.Compile(`^(?:(?:(.*?):(.*))?@)?(.*?)(/.*|$)`)
+	cfg, err := parseDSN(dsn)
 	if err != nil {
 		return nil
 	}
+	return cfg
+}
+
+func parseDSN(dsn string) (*Config, error) {
+	useTLS := false
+	switch {
+	case strings.HasPrefix(dsn, "etcds://"):
+		dsn, useTLS = strings.TrimPrefix(dsn, "etcds://"), true
+	case strings.HasPrefix(dsn, "etcd://"):
+		dsn = strings.TrimPrefix(dsn, "etcd://")
+	}
+	// the password may hold a ?, the params start after the last @
+	var query string
+	if i := strings.Index(dsn[strings.LastIndex(dsn, "@")+1:], "?"); i >= 0 {
+		i += strings.LastIndex(dsn, "@") + 1
+		dsn, query = dsn[:i], dsn[i+1:]
+	}
+
+	rg, err := regexp.Compile(`^(?:(?:(.*?):(.*))?@)?(.*?)(/.*|$)`)
+	if err != nil {
+		return nil, err
+	}
 	ss := rg.FindStringSubmatch(dsn)
 	cfg := &Config{
 		Addrs:    ss[3],
 		Username: ss[1],
 		Password: ss[2],
 		Path:     ss[4],
+		TLS:      useTLS,
 	}
 	if cfg.Addrs == "" {
-		return nil
+		return nil, errors.New("no address")
 	}
 	if !strings.HasSuffix(cfg.Path, "/") {
 		cfg.Path = fmt.Sprintf("%s/", cfg.Path)
 	}
 
-	return cfg
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range params {
+		val := values[len(values)-1]
+		switch name {
+		case "cacert":
+			cfg.CACert, cfg.TLS = val, true
+		case "cert":
+			cfg.Cert, cfg.TLS = val, true
+		case "key":
+			cfg.Key, cfg.TLS = val, true
+		case "server_name":
+			cfg.ServerName, cfg.TLS = val, true
+		case "insecure_skip_verify":
+			cfg.InsecureSkipVerify, err = strconv.ParseBool(val)
+			cfg.TLS = true
+		case "dial_timeout":
+			cfg.DialTimeout, err = parseDuration(val)
+		case "request_timeout":
+			cfg.RequestTimeout, err = parseDuration(val)
+		case "retry_interval":
+			cfg.RetryInterval, err = parseDuration(val)
+		case "keepalive":
+			cfg.Keepalive, err = parseDuration(val)
+		case "retries":
+			cfg.Retries, err = parsePositive(val)
+		case "max_call_recv_size":
+			cfg.MaxCallRecvSize, err = parsePositive(val)
+		default:
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, val)
+		}
+	}
+	return cfg, nil
+}
+
+// parseDuration accepts positive time.ParseDuration strings and seconds
+func parseDuration(val string) (time.Duration, error) {
+	d, err := time.ParseDuration(val)
+	if sec, e := strconv.ParseFloat(val, 64); e == nil {
+		d, err = time.Duration(sec*float64(time.Second)), nil
+	}
+	if err == nil && d <= 0 {
+		err = errors.New("not positive")
+	}
+	return d, err
+}
+
+func parsePositive(val string) (int, error) {
+	n, err := strconv.Atoi(val)
+	if err == nil && n <= 0 {
+		err = errors.New("not positive")
+	}
+	return n, err
+}
+
+// TLSConfig builds the tls config of the client, nil when tls is off
+func (cfg *Config) TLSConfig() (*tls.Config, error) {
+	if !cfg.TLS {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACert != "" {
+		pem, err := ioutil.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in cacert %s", cfg.CACert)
+		}
+	}
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, errors.New("cert and key must be given together")
+	}
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
 }
 
 func NewClient(dsn string) (*Client, error) {
-	cfg := ParseDSN(dsn)
-	if cfg == nil || cfg.Addrs == "" {
-		return nil, errors.New("invalid conf")
+	cfg, err := parseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conf: %s", err.Error())
 	}
+	tlsCfg, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := strings.Split(cfg.Addrs, ",")
+	if tlsCfg != nil {
+		// clientv3 only dials with tls the endpoints with an https scheme
+		for i, ep := range endpoints {
+			if !strings.Contains(ep, "://") {
+				endpoints[i] = "https://" + ep
+			}
+		}
+	}
+	opts := cfg.ConnOptions.withDefaults()
 	var cli *clientv3.Client
 
-	if err := utils.Retries(3, 3*time.Second, func(_ int) error {
+	if err := utils.Retries(opts.Retries, opts.RetryInterval, func(_ int) error {
 		c, e := clientv3.New(clientv3.Config{
-			Endpoints:   strings.Split(cfg.Addrs, ","),
-			DialTimeout: 3 * time.Second,
-			Username:    cfg.Username,
-			Password:    cfg.Password,
+			Endpoints:          endpoints,
+			DialTimeout:        opts.DialTimeout,
+			DialKeepAliveTime:  opts.Keepalive,
+			MaxCallRecvMsgSize: opts.MaxCallRecvSize,
+			Username:           cfg.Username,
+			Password:           cfg.Password,
+			TLS:                tlsCfg,
 		})
 		cli = c
 		return e
@@ -66,13 +299,72 @@ func NewClient(dsn string) (*Client, error) {
 		return nil, err
 	}
 	return &Client{
-		Client: cli,
+		Client:         cli,
+		requestTimeout: opts.RequestTimeout,
 	}, nil
 }
 
+// GetOptions tunes a read
+type GetOptions struct {
+	// Revision reads the values at a past revision, 0 for the current one
+	Revision int64
+}
+
+func (o GetOptions) ops() []clientv3.OpOption {
+	if o.Revision > 0 {
+		return []clientv3.OpOption{clientv3.WithRev(o.Revision)}
+	}
+	return nil
+}
+
+// PutOptions tunes a write
+type PutOptions struct {
+	// Lease attaches the key to a lease, 0 for none
+	Lease int64
+}
+
+// DeleteOptions tunes a delete
+type DeleteOptions struct {
+	// Prefix deletes every key with the prefix key
+	Prefix bool
+}
+
+// CommitOptions tunes CommitCtx
+type CommitOptions struct {
+	// MaxOps is the max ops per transaction, DefaultMaxTxnOps when 0
+	MaxOps int
+	// GuardPrefix fails a transaction with ErrTxnConflict if any key under it
+	// was modified after Rev, or after the previous transaction
+	GuardPrefix string
+	// GuardKeys does the same for single keys, e.g. the dirs above a prefix
+	GuardKeys []string
+	Rev       int64
+	// Always are added to every transaction, e.g. an audit record
+	Always []clientv3.Op
+}
+
+// requestCtx bounds a request by the request timeout, unless ctx has a
+// deadline of its own
+func (ec *Client) requestCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, ec.requestTimeout)
+}
+
+// timeoutCtx bounds a request by the request timeout
+func (ec *Client) timeoutCtx() (context.Context, context.CancelFunc) {
+	return ec.requestCtx(context.Background())
+}
+
 func (ec *Client) Get(key string) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	resp, err := ec.Client.Get(ctx, key)
+	return ec.GetCtx(context.Background(), key, GetOptions{})
+}
+
+// GetCtx reads key, "" when it doesn't exist
+func (ec *Client) GetCtx(ctx context.Context, key string, opts GetOptions) (string, error) {
+	ctx, cancel := ec.requestCtx(ctx)
+	resp, err := ec.Client.Get(ctx, key, opts.ops()...)
 	cancel()
 	if err != nil || len(resp.Kvs) == 0 {
 		return "", err
@@ -80,40 +372,604 @@ func (ec *Client) Get(key string) (string, error) {
 	return string(resp.Kvs[0].Value), nil
 }
 
+// GetKV reads key with its metadata. A missing key has Exists false and the
+// error ErrKeyNotFound, while a key with an empty value exists.
+func (ec *Client) GetKV(key string) (KVResult, error) {
+	return ec.GetKVCtx(context.Background(), key, GetOptions{})
+}
+
+// GetKVCtx is GetKV with a context and options
+func (ec *Client) GetKVCtx(ctx context.Context, key string, opts GetOptions) (KVResult, error) {
+	ctx, cancel := ec.requestCtx(ctx)
+	resp, err := ec.Client.Get(ctx, key, opts.ops()...)
+	cancel()
+	if err != nil {
+		return KVResult{}, err
+	}
+	if len(resp.Kvs) == 0 {
+		return KVResult{KV: KV{Key: key}}, ErrKeyNotFound
+	}
+	item := resp.Kvs[0]
+	return KVResult{
+		KV: KV{
+			Key:            string(item.Key),
+			Value:          string(item.Value),
+			CreateRevision: item.CreateRevision,
+			ModRevision:    item.ModRevision,
+			Version:        item.Version,
+			Lease:          item.Lease,
+		},
+		Exists: true,
+	}, nil
+}
+
 func (ec *Client) GetWithPrefix(key string) (map[string]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	resp, err := ec.Client.Get(ctx, key, clientv3.WithPrefix())
+	kvs, _, err := ec.GetWithPrefixRev(key)
+	return kvs, err
+}
+
+// GetWithPrefixRev is GetWithPrefix which also returns the revision the kvs
+// were read at
+func (ec *Client) GetWithPrefixRev(key string) (map[string]string, int64, error) {
+	return ec.GetWithPrefixCtx(context.Background(), key, GetOptions{})
+}
+
+// GetWithPrefixCtx reads the keys with prefix key and returns them with the
+// revision they were read at
+func (ec *Client) GetWithPrefixCtx(ctx context.Context, key string, opts GetOptions) (map[string]string, int64, error) {
+	ctx, cancel := ec.requestCtx(ctx)
+	resp, err := ec.Client.Get(ctx, key, append(opts.ops(), clientv3.WithPrefix())...)
 	cancel()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	var kvs = map[string]string{}
 	for _, item := range resp.Kvs {
 		kvs[string(item.Key)] = string(item.Value)
 	}
+	return kvs, resp.Header.Revision, nil
+}
+
+// RangeIter reads the keys with a prefix page by page, sorted by key, all at
+// the revision of the first page so the pages are consistent with each other
+type RangeIter struct {
+	ec   *Client
+	ctx  context.Context
+	opts RangeOptions
+	// the next page is read from [key, end)
+	key  string
+	end  string
+	rev  int64
+	page []KV
+	done bool
+	err  error
+}
+
+// RangeOptions tunes RangeWithOptions
+type RangeOptions struct {
+	// PageSize is the number of keys per request, DefaultPageSize when 0
+	PageSize int
+	// MinModRevision skips the keys last modified before it, on the server
+	MinModRevision int64
+	// Descend yields the keys in descending order
+	Descend bool
+}
+
+// Range returns an iterator over the keys with prefix, pageSize keys per
+// request, DefaultPageSize when 0. Every page is bounded by the request
+// timeout rather than the whole range.
+func (ec *Client) Range(ctx context.Context, prefix string, pageSize int) *RangeIter {
+	return ec.RangeWithOptions(ctx, prefix, RangeOptions{PageSize: pageSize})
+}
+
+// RangeWithOptions is Range with options
+func (ec *Client) RangeWithOptions(ctx context.Context, prefix string, opts RangeOptions) *RangeIter {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	key := prefix
+	if key == "" {
+		key = "\x00"
+	}
+	return &RangeIter{
+		ec:   ec,
+		ctx:  ctx,
+		opts: opts,
+		key:  key,
+		end:  clientv3.GetPrefixRangeEnd(prefix),
+	}
+}
+
+// Next reads the next page, it returns false after the last one or on error
+func (it *RangeIter) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+	opts := []clientv3.OpOption{clientv3.WithRange(it.end), clientv3.WithLimit(int64(it.opts.PageSize))}
+	if it.rev > 0 {
+		opts = append(opts, clientv3.WithRev(it.rev))
+	}
+	if it.opts.MinModRevision > 0 {
+		opts = append(opts, clientv3.WithMinModRev(it.opts.MinModRevision))
+	}
+	if it.opts.Descend {
+		opts = append(opts, clientv3.WithSort(clientv3.SortByKey, clientv3.SortDescend))
+	}
+	ctx, cancel := it.ec.requestCtx(it.ctx)
+	resp, err := it.ec.Client.Get(ctx, it.key, opts...)
+	cancel()
+	if err != nil {
+		it.err = err
+		return false
+	}
+	if it.rev == 0 {
+		it.rev = resp.Header.Revision
+	}
+	it.page = it.page[:0]
+	for _, item := range resp.Kvs {
+		it.page = append(it.page, KV{
+			Key:            string(item.Key),
+			Value:          string(item.Value),
+			CreateRevision: item.CreateRevision,
+			ModRevision:    item.ModRevision,
+			Version:        item.Version,
+			Lease:          item.Lease,
+		})
+	}
+	switch {
+	case !resp.More || len(resp.Kvs) == 0:
+		it.done = true
+	case it.opts.Descend:
+		it.end = string(resp.Kvs[len(resp.Kvs)-1].Key)
+	default:
+		it.key = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+	}
+	return len(it.page) > 0 || !it.done
+}
+
+// Page returns the kvs read by the last Next, they are overwritten by the
+// following one
+func (it *RangeIter) Page() []KV {
+	return it.page
+}
+
+// Rev returns the revision the pages are read at, once Next was called
+func (it *RangeIter) Rev() int64 {
+	return it.rev
+}
+
+// Err returns the error which stopped Next
+func (it *RangeIter) Err() error {
+	return it.err
+}
+
+// GetWithPrefixPaged is GetWithPrefixCtx reading the keys through Range, for
+// prefixes too large for a single response
+func (ec *Client) GetWithPrefixPaged(ctx context.Context, key string, pageSize int) (map[string]string, int64, error) {
+	it := ec.Range(ctx, key, pageSize)
+	kvs := map[string]string{}
+	for it.Next() {
+		for _, kv := range it.Page() {
+			kvs[kv.Key] = kv.Value
+		}
+	}
+	if it.Err() != nil {
+		return nil, 0, it.Err()
+	}
+	return kvs, it.Rev(), nil
+}
+
+// Keys returns the keys under prefix without their values
+func (ec *Client) Keys(prefix string) ([]string, error) {
+	ctx, cancel := ec.timeoutCtx()
+	resp, err := ec.Client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		keys = append(keys, string(item.Key))
+	}
+	return keys, nil
+}
+
+// GetAt reads key at revision rev
+func (ec *Client) GetAt(key string, rev int64) (string, error) {
+	return ec.GetCtx(context.Background(), key, GetOptions{Revision: rev})
+}
+
+// GetWithPrefixAt is GetWithPrefix reading the values at revision rev, it
+// fails with rpctypes.ErrCompacted once rev has been compacted
+func (ec *Client) GetWithPrefixAt(key string, rev int64) (map[string]string, error) {
+	kvs, _, err := ec.GetWithPrefixCtx(context.Background(), key, GetOptions{Revision: rev})
+	return kvs, err
+}
+
+// Revision returns the current revision, in a single request
+func (ec *Client) Revision() (int64, error) {
+	ctx, cancel := ec.timeoutCtx()
+	resp, err := ec.Client.Get(ctx, "/", clientv3.WithCountOnly())
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
+// Revisions returns the oldest revision which has not been compacted and the
+// current revision. Finding the oldest one takes a bisection, use Revision
+// when only the current one is needed.
+func (ec *Client) Revisions() (int64, int64, error) {
+	current, err := ec.Revision()
+	if err != nil {
+		return 0, 0, err
+	}
+	lo, hi := int64(1), current
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		// each probe has its own timeout, a long history takes many of them
+		ctx, cancel := ec.timeoutCtx()
+		_, err := ec.Client.Get(ctx, "/", clientv3.WithCountOnly(), clientv3.WithRev(mid))
+		cancel()
+		if err == rpctypes.ErrCompacted {
+			lo = mid + 1
+		} else if err != nil {
+			return 0, 0, err
+		} else {
+			hi = mid
+		}
+	}
+	return lo, current, nil
+}
+
+// GetKVsWithPrefix returns the keys with prefix key sorted by key, and the
+// revision they were read at
+func (ec *Client) GetKVsWithPrefix(key string) ([]KV, int64, error) {
+	ctx, cancel := ec.timeoutCtx()
+	resp, err := ec.Client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	cancel()
+	if err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]KV, 0, len(resp.Kvs))
+	for _, item := range resp.Kvs {
+		kvs = append(kvs, KV{
+			Key:            string(item.Key),
+			Value:          string(item.Value),
+			CreateRevision: item.CreateRevision,
+			ModRevision:    item.ModRevision,
+			Version:        item.Version,
+			Lease:          item.Lease,
+		})
+	}
+	return kvs, resp.Header.Revision, nil
+}
+
+// History returns the past values of key newest first, back to its first
+// creation or the compaction point. A deletion is listed as a KV with Version
+// 0 at the revision it happened, so a key which was deleted and put again
+// shows all of its lives
+func (ec *Client) History(key string) ([]KV, error) {
+	kvs, rev, err := ec.walkHistory(key, 0)
+	if err != nil {
+		return nil, err
+	}
+	// Get can't read a deleted key, the lives before the current one are
+	// replayed with a watch
+	until := rev
+	if len(kvs) > 0 {
+		until = kvs[len(kvs)-1].ModRevision - 1
+	}
+	evs, err := ec.pastEvents(key, false, until)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(evs) - 1; i >= 0; i-- {
+		kvs = append(kvs, toKV(evs[i].Kv))
+	}
 	return kvs, nil
 }
 
-func (ec *Client) Put(key, val string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	_, err := ec.Client.Put(ctx, key, val)
+// HistoryWithPrefix returns the history of every key with prefix key, the
+// deleted ones included, sorted by key and newest first for each key
+func (ec *Client) HistoryWithPrefix(key string) ([]KV, error) {
+	cur, rev, err := ec.GetKVsWithPrefix(key)
+	if err != nil {
+		return nil, err
+	}
+	evs, err := ec.pastEvents(key, true, rev)
+	if err != nil {
+		return nil, err
+	}
+	past := map[string][]*mvccpb.KeyValue{}
+	var keys []string
+	for _, ev := range evs {
+		k := string(ev.Kv.Key)
+		if _, ok := past[k]; !ok {
+			keys = append(keys, k)
+		}
+		past[k] = append(past[k], ev.Kv)
+	}
+	exists := map[string]bool{}
+	for _, kv := range cur {
+		exists[kv.Key] = true
+		if _, ok := past[kv.Key]; !ok {
+			keys = append(keys, kv.Key)
+		}
+	}
+	sort.Strings(keys)
+
+	var kvs []KV
+	for _, k := range keys {
+		before := rev + 1
+		if exists[k] {
+			life, _, err := ec.walkHistory(k, rev)
+			if err != nil {
+				return nil, err
+			}
+			if len(life) > 0 {
+				before = life[len(life)-1].ModRevision
+			}
+			kvs = append(kvs, life...)
+		}
+		items := past[k]
+		for i := len(items) - 1; i >= 0; i-- {
+			if items[i].ModRevision < before {
+				kvs = append(kvs, toKV(items[i]))
+			}
+		}
+	}
+	return kvs, nil
+}
+
+// walkHistory walks the current life of key back from revision rev, 0 for the
+// latest, until its creation or the compaction point, and returns the
+// revision it started at
+func (ec *Client) walkHistory(key string, rev int64) ([]KV, int64, error) {
+	var kvs []KV
+	var start int64
+	for {
+		var opts []clientv3.OpOption
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		ctx, cancel := ec.timeoutCtx()
+		resp, err := ec.Client.Get(ctx, key, opts...)
+		cancel()
+		if err == rpctypes.ErrCompacted {
+			return kvs, start, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if start == 0 {
+			start = resp.Header.Revision
+		}
+		if len(resp.Kvs) == 0 {
+			return kvs, start, nil
+		}
+		item := resp.Kvs[0]
+		kvs = append(kvs, toKV(item))
+		if item.Version <= 1 {
+			return kvs, start, nil
+		}
+		rev = item.ModRevision - 1
+	}
+}
+
+// watchBatchMaxRevs is the most revisions etcd sends a watcher catching up in
+// one response, one with fewer holds every event up to its header revision
+const watchBatchMaxRevs = 1000
+
+// pastEvents replays the events of key, or of the keys with prefix key, from
+// the oldest revision which has not been compacted up to revision until. It
+// stops at the first event after until, or once a response shows the watch
+// has caught up with until. A watch with nothing to send stays silent, so
+// when no response comes for the request timeout it fails.
+func (ec *Client) pastEvents(key string, prefix bool, until int64) ([]*clientv3.Event, error) {
+	oldest, _, err := ec.Revisions()
+	if err != nil {
+		return nil, err
+	}
+	if until < oldest {
+		return nil, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts := []clientv3.OpOption{clientv3.WithRev(oldest), clientv3.WithProgressNotify()}
+	if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	wc := ec.Client.Watch(ctx, key, opts...)
+
+	var evs []*clientv3.Event
+	for {
+		select {
+		case wresp, ok := <-wc:
+			if !ok {
+				return nil, errors.New("watch closed")
+			}
+			if wresp.CompactRevision != 0 {
+				// compacted after oldest was read, the events before it are gone
+				cancel()
+				return ec.pastEvents(key, prefix, until)
+			}
+			if err := wresp.Err(); err != nil {
+				return nil, err
+			}
+			done := wresp.Header.Revision >= until && batchRevs(wresp.Events) < watchBatchMaxRevs
+			for _, ev := range wresp.Events {
+				if ev.Kv.ModRevision >= until {
+					done = true
+				}
+				if ev.Kv.ModRevision <= until {
+					evs = append(evs, ev)
+				}
+			}
+			if done {
+				return evs, nil
+			}
+		case <-time.After(ec.requestTimeout):
+			return nil, fmt.Errorf("history of %s: no event up to revision %d came within %s, it may have none since revision %d", key, until, ec.requestTimeout, oldest)
+		}
+	}
+}
+
+// batchRevs counts the revisions of the events of a watch response
+func batchRevs(evs []*clientv3.Event) int {
+	n := 0
+	for i, ev := range evs {
+		if i == 0 || ev.Kv.ModRevision != evs[i-1].Kv.ModRevision {
+			n++
+		}
+	}
+	return n
+}
+
+func toKV(item *mvccpb.KeyValue) KV {
+	return KV{
+		Key:            string(item.Key),
+		Value:          string(item.Value),
+		CreateRevision: item.CreateRevision,
+		ModRevision:    item.ModRevision,
+		Version:        item.Version,
+		Lease:          item.Lease,
+	}
+}
+
+// LeaseTTL returns the remaining seconds of a lease, -1 if it has expired
+func (ec *Client) LeaseTTL(lease int64) (int64, error) {
+	ctx, cancel := ec.timeoutCtx()
+	resp, err := ec.Client.TimeToLive(ctx, clientv3.LeaseID(lease))
 	cancel()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	return resp.TTL, nil
 }
 
-func (ec *Client) Delete(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	_, err := ec.Client.Delete(ctx, key)
+// Grant creates a lease expiring after ttl seconds
+func (ec *Client) Grant(ttl int64) (int64, error) {
+	ctx, cancel := ec.timeoutCtx()
+	resp, err := ec.Client.Grant(ctx, ttl)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	return int64(resp.ID), nil
+}
+
+func (ec *Client) Put(key, val string) error {
+	return ec.PutCtx(context.Background(), key, val, PutOptions{})
+}
+
+// PutCtx writes val to key
+func (ec *Client) PutCtx(ctx context.Context, key, val string, opts PutOptions) error {
+	var ops []clientv3.OpOption
+	if opts.Lease != 0 {
+		ops = append(ops, clientv3.WithLease(clientv3.LeaseID(opts.Lease)))
+	}
+	ctx, cancel := ec.requestCtx(ctx)
+	_, err := ec.Client.Put(ctx, key, val, ops...)
 	cancel()
 	return err
 }
 
+func (ec *Client) Delete(key string) error {
+	return ec.DeleteCtx(context.Background(), key, DeleteOptions{})
+}
+
 func (ec *Client) DeleteWithPrefix(key string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	_, err := ec.Client.Delete(ctx, key, clientv3.WithPrefix())
+	return ec.DeleteCtx(context.Background(), key, DeleteOptions{Prefix: true})
+}
+
+// DeleteCtx deletes key, or every key with the prefix key
+func (ec *Client) DeleteCtx(ctx context.Context, key string, opts DeleteOptions) error {
+	var ops []clientv3.OpOption
+	if opts.Prefix {
+		ops = append(ops, clientv3.WithPrefix())
+	}
+	ctx, cancel := ec.requestCtx(ctx)
+	_, err := ec.Client.Delete(ctx, key, ops...)
 	cancel()
 	return err
 }
+
+// Commit applies ops in transactions of at most maxOps ops and MaxTxnBytes,
+// halving the size when the server limit is lower, and returns the revision of
+// the last one.
+// When guardPrefix is not empty a transaction fails with ErrTxnConflict if any
+// key under it was modified after rev, or after the previous transaction.
+// The always ops, e.g. an audit record, are added to every transaction.
+func (ec *Client) Commit(ops []clientv3.Op, maxOps int, guardPrefix string, rev int64, always ...clientv3.Op) (int64, error) {
+	return ec.CommitCtx(context.Background(), ops, CommitOptions{
+		MaxOps:      maxOps,
+		GuardPrefix: guardPrefix,
+		Rev:         rev,
+		Always:      always,
+	})
+}
+
+func opsBytes(ops []clientv3.Op) int {
+	n := 0
+	for _, op := range ops {
+		n += len(op.KeyBytes()) + len(op.ValueBytes())
+	}
+	return n
+}
+
+// CommitCtx is Commit which stops between transactions once ctx is done, a
+// transaction already sent runs to its end. The error tells how many ops were
+// applied
+func (ec *Client) CommitCtx(ctx context.Context, ops []clientv3.Op, opts CommitOptions) (int64, error) {
+	maxOps, guardPrefix, rev, always := opts.MaxOps, opts.GuardPrefix, opts.Rev, opts.Always
+	if maxOps <= 0 {
+		maxOps = DefaultMaxTxnOps
+	}
+	for applied := 0; applied < len(ops); {
+		size := maxOps - len(always)
+		if size < 1 {
+			size = 1
+		}
+		// a transaction has at least one op, then as many as fit
+		budget := MaxTxnBytes - opsBytes(always)
+		end := applied + 1
+		for n := opsBytes(ops[applied:end]); end < len(ops) && end-applied < size; end++ {
+			if n += opsBytes(ops[end : end+1]); n > budget {
+				break
+			}
+		}
+		var cmps []clientv3.Cmp
+		if guardPrefix != "" {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(guardPrefix), "<", rev+1).WithPrefix())
+		}
+		for _, k := range opts.GuardKeys {
+			cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "<", rev+1))
+		}
+		if err := ctx.Err(); err != nil {
+			return rev, fmt.Errorf("applied %d of %d ops: %s", applied, len(ops), err.Error())
+		}
+		// a signal must not abort a transaction in flight, its outcome would
+		// be unknown
+		txnCtx, cancel := ec.timeoutCtx()
+		resp, err := ec.Client.Txn(txnCtx).If(cmps...).Then(append(ops[applied:end:end], always...)...).Commit()
+		cancel()
+		if err == rpctypes.ErrTooManyOps && maxOps > 1 {
+			maxOps /= 2
+			continue
+		}
+		if err != nil {
+			return rev, fmt.Errorf("applied %d of %d ops: %s", applied, len(ops), err.Error())
+		}
+		if !resp.Succeeded {
+			if applied > 0 {
+				return rev, fmt.Errorf("applied %d of %d ops: %s", applied, len(ops), ErrTxnConflict.Error())
+			}
+			return rev, ErrTxnConflict
+		}
+		rev = resp.Header.Revision
+		applied = end
+	}
+	return rev, nil
+}
@@ -1,9 +1,14 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/magiconair/properties/assert"
 )
 
@@ -56,6 +61,61 @@ func TestParseConf(t *testing.T) {
 			Path:     "/",
 		},
 	)
+	assert.Equal(t,
+		ParseDSN("etcds://wby:p?s@w@localhost:2379/call/s"),
+		&Config{
+			Username: "wby",
+			Password: "p?s@w",
+			Addrs:    "localhost:2379",
+			Path:     "/call/s/",
+			TLS:      true,
+		},
+	)
+	assert.Equal(t,
+		ParseDSN("etcd://localhost:2379/call?cacert=/etc/ca.pem&cert=/etc/c.pem&key=/etc/k.pem&server_name=etcd&insecure_skip_verify=true"),
+		&Config{
+			Addrs:              "localhost:2379",
+			Path:               "/call/",
+			TLS:                true,
+			CACert:             "/etc/ca.pem",
+			Cert:               "/etc/c.pem",
+			Key:                "/etc/k.pem",
+			ServerName:         "etcd",
+			InsecureSkipVerify: true,
+		},
+	)
+	assert.Equal(t,
+		ParseDSN("localhost:2379/call?dial_timeout=5s&request_timeout=2.5&retries=1&retry_interval=500ms&keepalive=30s&max_call_recv_size=10485760"),
+		&Config{
+			Addrs: "localhost:2379",
+			Path:  "/call/",
+			ConnOptions: ConnOptions{
+				DialTimeout:     5 * time.Second,
+				RequestTimeout:  2500 * time.Millisecond,
+				Retries:         1,
+				RetryInterval:   500 * time.Millisecond,
+				Keepalive:       30 * time.Second,
+				MaxCallRecvSize: 10485760,
+			},
+		},
+	)
+	assert.Equal(t, ParseDSN("localhost:2379?request_timeout=-1s"), (*Config)(nil))
+	assert.Equal(t, ParseDSN("localhost:2379?retries=0"), (*Config)(nil))
+	assert.Equal(t, ParseDSN("localhost:2379?insecure_skip_verify=maybe"), (*Config)(nil))
+	assert.Equal(t, ParseDSN("localhost:2379?unknown=1"), (*Config)(nil))
+}
+
+func TestConfig_TLSConfig(t *testing.T) {
+	tlsCfg, err := ParseDSN("localhost:2379").TLSConfig()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tlsCfg == nil, true)
+
+	tlsCfg, err = ParseDSN("etcds://localhost:2379?server_name=etcd").TLSConfig()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, tlsCfg.ServerName, "etcd")
+
+	_, err = ParseDSN("localhost:2379?cert=/etc/c.pem").TLSConfig()
+	assert.Equal(t, err.Error(), "cert and key must be given together")
 }
 
 func TestClient_Get(t *testing.T) {
@@ -63,6 +123,260 @@ func TestClient_Get(t *testing.T) {
 	val, err := cli.Get("/test")
 	t.Log(err)
 	t.Log(val)
-	fmt.Printf("%", val)
+	fmt.Printf("%s", val)
+
+}
+
+func TestClient_Commit(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	cli.DeleteWithPrefix("/test_commit/")
+
+	var ops []clientv3.Op
+	for i := 0; i < 300; i++ {
+		ops = append(ops, clientv3.OpPut(fmt.Sprintf("/test_commit/%d", i), "v"))
+	}
+	kvs, rev, err := cli.GetWithPrefixRev("/test_commit/")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 0)
+	rev, err = cli.Commit(ops, 1000, "/test_commit/", rev)
+	assert.Equal(t, err, nil)
+	kvs, _ = cli.GetWithPrefix("/test_commit/")
+	assert.Equal(t, len(kvs), 300)
+
+	cli.Put("/test_commit/1", "changed")
+	_, err = cli.Commit([]clientv3.Op{clientv3.OpDelete("/test_commit/2")}, 0, "/test_commit/", rev)
+	assert.Equal(t, err, ErrTxnConflict)
+
+	rev, err = cli.Commit([]clientv3.Op{clientv3.OpPut("/test_commit/1", "v")}, 0, "", 0)
+	assert.Equal(t, err, nil)
+	opts := CommitOptions{GuardKeys: []string{"/test_commit/1", "/test_commit/missing"}, Rev: rev}
+	_, err = cli.CommitCtx(context.Background(), []clientv3.Op{clientv3.OpDelete("/test_commit/2")}, opts)
+	assert.Equal(t, err, nil)
+	cli.Put("/test_commit/missing", "v")
+	_, err = cli.CommitCtx(context.Background(), []clientv3.Op{clientv3.OpDelete("/test_commit/3")}, opts)
+	assert.Equal(t, err, ErrTxnConflict)
+	cli.DeleteWithPrefix("/test_commit/")
+}
+
+func TestClient_Ctx(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	ctx := context.Background()
+	assert.Equal(t, cli.DeleteCtx(ctx, "/test_ctx/", DeleteOptions{Prefix: true}), nil)
+	assert.Equal(t, cli.PutCtx(ctx, "/test_ctx/a", "v1", PutOptions{}), nil)
+	kvs, rev, err := cli.GetWithPrefixCtx(ctx, "/test_ctx/", GetOptions{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, kvs, map[string]string{"/test_ctx/a": "v1"})
+	assert.Equal(t, cli.PutCtx(ctx, "/test_ctx/a", "v2", PutOptions{}), nil)
+	val, err := cli.GetCtx(ctx, "/test_ctx/a", GetOptions{Revision: rev})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, val, "v1")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = cli.GetCtx(cancelled, "/test_ctx/a", GetOptions{})
+	assert.Equal(t, err != nil, true)
+	_, err = cli.CommitCtx(cancelled, []clientv3.Op{clientv3.OpDelete("/test_ctx/a")}, CommitOptions{})
+	assert.Equal(t, err.Error(), "applied 0 of 1 ops: context canceled")
+	val, _ = cli.Get("/test_ctx/a")
+	assert.Equal(t, val, "v2")
+	cli.DeleteWithPrefix("/test_ctx/")
+}
+
+func TestClient_GetKV(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	cli.Delete("/test_getkv")
+	kv, err := cli.GetKV("/test_getkv")
+	assert.Equal(t, err, ErrKeyNotFound)
+	assert.Equal(t, kv.Exists, false)
+
+	cli.Put("/test_getkv", "")
+	kv, err = cli.GetKV("/test_getkv")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, kv.Exists, true)
+	assert.Equal(t, kv.Value, "")
+	assert.Equal(t, kv.Version, int64(1))
+	cli.Delete("/test_getkv")
+}
+
+func TestClient_Range(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	cli.DeleteWithPrefix("/test_range/")
+	for i := 0; i < 25; i++ {
+		cli.Put(fmt.Sprintf("/test_range/%02d", i), fmt.Sprint(i))
+	}
+	cli.Put("/test_range0", "out of the prefix")
+
+	it := cli.Range(context.Background(), "/test_range/", 10)
+	var pages, keys int
+	for it.Next() {
+		if pages == 0 {
+			// written after the first page, not seen by the pinned revision
+			cli.Put("/test_range/99", "late")
+		}
+		pages++
+		keys += len(it.Page())
+	}
+	assert.Equal(t, it.Err(), nil)
+	assert.Equal(t, pages, 3)
+	assert.Equal(t, keys, 25)
+
+	kvs, rev, err := cli.GetWithPrefixPaged(context.Background(), "/test_range/", 7)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 26)
+	assert.Equal(t, kvs["/test_range/07"], "7")
+	assert.Equal(t, rev > it.Rev(), true)
+
+	// newest first, skipping the keys written before the late one
+	it = cli.RangeWithOptions(context.Background(), "/test_range/", RangeOptions{PageSize: 2, MinModRevision: it.Rev() + 1, Descend: true})
+	var desc []string
+	for it.Next() {
+		for _, kv := range it.Page() {
+			desc = append(desc, kv.Key)
+		}
+	}
+	assert.Equal(t, it.Err(), nil)
+	assert.Equal(t, desc, []string{"/test_range/99"})
+	it = cli.RangeWithOptions(context.Background(), "/test_range/", RangeOptions{PageSize: 4, Descend: true})
+	desc = desc[:0]
+	for it.Next() {
+		for _, kv := range it.Page() {
+			desc = append(desc, kv.Key)
+		}
+	}
+	assert.Equal(t, len(desc), 26)
+	assert.Equal(t, desc[0], "/test_range/99")
+	assert.Equal(t, desc[25], "/test_range/00")
+	cli.DeleteWithPrefix("/test_range/")
+	cli.Delete("/test_range0")
+}
+
+func TestClient_CommitBytes(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	cli.DeleteWithPrefix("/test_commit_bytes/")
+
+	// 3MB in all, over the request limit of a single transaction
+	val := strings.Repeat("v", 100*1024)
+	var ops []clientv3.Op
+	for i := 0; i < 30; i++ {
+		ops = append(ops, clientv3.OpPut(fmt.Sprintf("/test_commit_bytes/%d", i), val))
+	}
+	_, err = cli.Commit(ops, 0, "", 0, clientv3.OpPut("/test_commit_bytes_always", "a"))
+	assert.Equal(t, err, nil)
+	kvs, err := cli.GetWithPrefix("/test_commit_bytes/")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 30)
+	cli.DeleteWithPrefix("/test_commit_bytes")
+}
+
+func TestClient_History(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	// past lives stay in the history, a new key per run keeps it short
+	key := fmt.Sprintf("/test_history_%d", time.Now().UnixNano())
+	for i := 0; i < 3; i++ {
+		cli.Put(key, fmt.Sprintf("v%d", i))
+	}
+	kvs, err := cli.History(key)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 3)
+	assert.Equal(t, kvs[0].Value, "v2")
+	assert.Equal(t, kvs[2].Value, "v0")
+	assert.Equal(t, kvs[2].Version, int64(1))
+	cli.Delete(key)
+}
+
+func TestClient_HistoryRecreated(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	prefix := fmt.Sprintf("/test_history_recreated_%d/", time.Now().UnixNano())
+	cli.Put(prefix+"a", "v0")
+	cli.Put(prefix+"a", "v1")
+	cli.Delete(prefix + "a")
+	cli.Put(prefix+"a", "v2")
+	cli.Put(prefix+"b", "v0")
+	cli.Delete(prefix + "b")
+
+	kvs, err := cli.History(prefix + "a")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 4)
+	assert.Equal(t, kvs[0].Value, "v2")
+	assert.Equal(t, kvs[0].Version, int64(1))
+	assert.Equal(t, kvs[1].Version, int64(0))
+	assert.Equal(t, kvs[2].Value, "v1")
+	assert.Equal(t, kvs[3].Value, "v0")
+
+	kvs, err = cli.History(prefix + "b")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 2)
+	assert.Equal(t, kvs[0].Version, int64(0))
+	assert.Equal(t, kvs[1].Value, "v0")
+
+	kvs, err = cli.HistoryWithPrefix(prefix)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(kvs), 6)
+	assert.Equal(t, kvs[0].Key, prefix+"a")
+	assert.Equal(t, kvs[3].Value, "v0")
+	assert.Equal(t, kvs[4].Key, prefix+"b")
+	assert.Equal(t, kvs[4].Version, int64(0))
+	cli.DeleteWithPrefix(prefix)
+}
+
+func TestClient_Revision(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	_, rev, err := cli.GetWithPrefixRev("/test_revision/")
+	assert.Equal(t, err, nil)
+	current, err := cli.Revision()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, current, rev)
+
+	oldest, current2, err := cli.Revisions()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, current2, current)
+	assert.Equal(t, oldest <= current, true)
+}
+
+func TestBatchRevs(t *testing.T) {
+	ev := func(rev int64) *clientv3.Event {
+		return &clientv3.Event{Kv: &mvccpb.KeyValue{ModRevision: rev}}
+	}
+	assert.Equal(t, batchRevs(nil), 0)
+	assert.Equal(t, batchRevs([]*clientv3.Event{ev(3), ev(3), ev(5)}), 2)
+}
+
+func TestClient_Lock(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	l, err := cli.Lock("/test_lock", "first", 0)
+	assert.Equal(t, err, nil)
+	_, err = cli.Lock("/test_lock", "second", 100*time.Millisecond)
+	assert.Equal(t, err.Error(), "lock /test_lock is held by first")
+	assert.Equal(t, l.Unlock(), nil)
+	l, err = cli.Lock("/test_lock", "second", time.Second)
+	assert.Equal(t, err, nil)
+	l.Unlock()
+}
 
+func TestClient_LockPath(t *testing.T) {
+	cli, err := NewClient("localhost:2379")
+	assert.Equal(t, err, nil)
+	ctx := context.Background()
+	l, err := cli.LockPath(ctx, "/test_lock_path", "/app", "first", 0)
+	assert.Equal(t, err, nil)
+	_, err = cli.LockPath(ctx, "/test_lock_path", "", "root", 100*time.Millisecond)
+	assert.Equal(t, err.Error(), "lock /test_lock_path is held by first")
+	_, err = cli.LockPath(ctx, "/test_lock_path", "/app/redis", "child", 100*time.Millisecond)
+	assert.Equal(t, err.Error(), "lock /test_lock_path/app/redis is held by first")
+	sibling, err := cli.LockPath(ctx, "/test_lock_path", "/application", "sibling", 100*time.Millisecond)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, sibling.Unlock(), nil)
+	assert.Equal(t, l.Unlock(), nil)
+	l, err = cli.LockPath(ctx, "/test_lock_path", "", "root", time.Second)
+	assert.Equal(t, err, nil)
+	l.Unlock()
 }
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/clientv3/concurrency"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+)
+
+// lockTTL is the ttl in seconds of the lock lease, a crashed holder releases
+// the lock when it expires
+const lockTTL = 10
+
+// Lock is a held etcd mutex
+type Lock struct {
+	session *concurrency.Session
+	key     string
+	timeout time.Duration
+	once    sync.Once
+}
+
+// Lock acquires the mutex at key and stores holder as its value, so a waiter
+// timing out can tell who has it. timeout 0 waits forever.
+func (ec *Client) Lock(key, holder string, timeout time.Duration) (*Lock, error) {
+	return ec.LockCtx(context.Background(), key, holder, timeout)
+}
+
+// LockCtx is Lock which also stops waiting once ctx is done
+func (ec *Client) LockCtx(ctx context.Context, key, holder string, timeout time.Duration) (*Lock, error) {
+	session, err := concurrency.NewSession(ec.Client, concurrency.WithTTL(lockTTL))
+	if err != nil {
+		return nil, err
+	}
+	parent := ctx
+	cancel := func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	mutex := concurrency.NewMutex(session, key)
+	if err := mutex.Lock(ctx); err != nil {
+		session.Close()
+		if err != context.DeadlineExceeded || parent.Err() != nil {
+			return nil, err
+		}
+		owner, err := ec.lockOwner(key)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("lock %s is held by %s", key, owner)
+	}
+
+	if err := ec.PutCtx(parent, mutex.Key(), holder, PutOptions{Lease: int64(session.Lease())}); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return &Lock{session: session, key: mutex.Key(), timeout: ec.requestTimeout}, nil
+}
+
+// LockPath acquires the lock of path among the locks under prefix, path is
+// like /app/redis and "" for the root. Locks of overlapping paths, one being
+// the other or under it, exclude each other, so a write to the root waits for
+// a write to a namespace and the other way round, while writes to sibling
+// paths go on together. The keys are those of a mutex at prefix+path.
+func (ec *Client) LockPath(ctx context.Context, prefix, path, holder string, timeout time.Duration) (*Lock, error) {
+	session, err := concurrency.NewSession(ec.Client, concurrency.WithTTL(lockTTL))
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s%s/%x", prefix, path, session.Lease())
+	pctx, cancel := ec.requestCtx(ctx)
+	resp, err := ec.Client.Put(pctx, key, holder, clientv3.WithLease(session.Lease()))
+	cancel()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	l := &Lock{session: session, key: key, timeout: ec.requestTimeout}
+
+	parent := ctx
+	cancel = func() {}
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	owner, err := ec.waitPaths(ctx, prefix, path, resp.Header.Revision)
+	if err != nil {
+		l.Unlock()
+		if err != context.DeadlineExceeded || parent.Err() != nil {
+			return nil, err
+		}
+		if owner == "" {
+			owner = "an unknown holder"
+		}
+		return nil, fmt.Errorf("lock %s is held by %s", prefix+path, owner)
+	}
+	return l, nil
+}
+
+// waitPaths waits until the locks under prefix created before rev whose path
+// overlaps path are released, on error it returns the holder it waited for
+func (ec *Client) waitPaths(ctx context.Context, prefix, path string, rev int64) (string, error) {
+	for {
+		gctx, cancel := ec.requestCtx(ctx)
+		resp, err := ec.Client.Get(gctx, prefix+"/", clientv3.WithPrefix(), clientv3.WithMaxCreateRev(rev-1))
+		cancel()
+		if err != nil {
+			return "", err
+		}
+		var last *mvccpb.KeyValue
+		for _, kv := range resp.Kvs {
+			key := strings.TrimPrefix(string(kv.Key), prefix)
+			if !overlaps(key[:strings.LastIndex(key, "/")], path) {
+				continue
+			}
+			if last == nil || kv.CreateRevision > last.CreateRevision {
+				last = kv
+			}
+		}
+		if last == nil {
+			return "", nil
+		}
+		if err := ec.waitDelete(ctx, string(last.Key), resp.Header.Revision); err != nil {
+			return string(last.Value), err
+		}
+	}
+}
+
+// waitDelete waits until key, read at rev, is deleted
+func (ec *Client) waitDelete(ctx context.Context, key string, rev int64) error {
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for wresp := range ec.Client.Watch(wctx, key, clientv3.WithRev(rev+1)) {
+		if err := wresp.Err(); err != nil {
+			return err
+		}
+		for _, ev := range wresp.Events {
+			if ev.Type == mvccpb.DELETE {
+				return nil
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errors.New("lost watcher waiting for delete")
+}
+
+// overlaps tells whether a and b are the same path or one is under the other
+func overlaps(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+func (ec *Client) lockOwner(key string) (string, error) {
+	ctx, cancel := ec.timeoutCtx()
+	defer cancel()
+	resp, err := ec.Client.Get(ctx, key+"/", clientv3.WithFirstCreate()...)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Kvs) == 0 || len(resp.Kvs[0].Value) == 0 {
+		return "an unknown holder", nil
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Unlock releases the lock, it is safe to call more than once
+func (l *Lock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+		defer cancel()
+		_, err = l.session.Client().Delete(ctx, l.key)
+		if e := l.session.Close(); err == nil {
+			err = e
+		}
+	})
+	return err
+}
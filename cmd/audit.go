@@ -0,0 +1,409 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/go-errors/errors"
+	"github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	auditPrefix = reservedPrefix + "audit/"
+	// auditChangesPrefix holds the changes of the entries too large to keep
+	// them inline, in parts of auditPartChanges changes
+	auditChangesPrefix = reservedPrefix + "audit_changes/"
+	auditInlineChanges = 100
+	auditPartChanges   = 1000
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "browse the audit log of the writes made by etcd-tool",
+	Long: `Every write command records who changed which keys, and why when
+--message is given, under ` + auditPrefix + ` in the same transaction as the
+change. Values are recorded as hashes only.
+
+Example:
+  etcd-tool put -e localhost:2379/my_project -c prod.json --message "raise pool size"
+  etcd-tool audit ls -e localhost:2379/my_project
+  etcd-tool audit show -e localhost:2379 20181024T100000.000000000Z-1a2b`,
+}
+
+var auditLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "list the audit entries touching the dsn path, newest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := auditArg.Ls(); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var auditShowCmd = &cobra.Command{
+	Use:   "show <id>",
+	Short: "print an audit entry with its changed keys",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := auditArg.Show(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var auditArg AuditArg
+
+type AuditArg struct {
+	Dsn   string
+	Limit int
+	Json  bool
+}
+
+// auditUser and auditMessage are recorded by every write command
+var auditUser, auditMessage string
+
+type AuditChange struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	// Old and New are hashes of the values, see valueHash
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+type AuditEntry struct {
+	ID      string        `json:"id"`
+	User    string        `json:"user"`
+	Host    string        `json:"host"`
+	Command string        `json:"command"`
+	Message string        `json:"message,omitempty"`
+	Time    time.Time     `json:"time"`
+	Changes []AuditChange `json:"changes,omitempty"`
+	// Keys is the number of changes and Prefix the dir of all the changed
+	// keys, Parts the number of parts the changes are split in
+	Keys   int    `json:"keys"`
+	Prefix string `json:"prefix"`
+	Parts  int    `json:"parts,omitempty"`
+	// Revision is the revision the change ended at, it is only known once
+	// committed so it is read from the entry key
+	Revision int64 `json:"revision,omitempty"`
+	// firstRevision is the revision of the first transaction of the change
+	firstRevision int64
+}
+
+func init() {
+	RootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(auditLsCmd)
+	auditCmd.AddCommand(auditShowCmd)
+
+	RootCmd.PersistentFlags().StringVar(&auditUser, "audit-user", currentUser(), "user recorded in the audit log")
+	RootCmd.PersistentFlags().StringVar(&auditMessage, "message", "", "why the change is made, recorded in the audit log")
+
+	auditCmd.PersistentFlags().StringVarP(&auditArg.Dsn, "etcd", "e", "", "etcd address")
+	auditLsCmd.Flags().IntVarP(&auditArg.Limit, "limit", "n", 20, "max entries to list, 0 for all")
+	auditCmd.PersistentFlags().BoolVar(&auditArg.Json, "json", false, "print as json")
+}
+
+func (a *AuditArg) Ls() error {
+	cfg := client.ParseDSN(a.Dsn)
+	if cfg == nil {
+		return errors.New("invalid params")
+	}
+	cli, err := client.NewClient(a.Dsn)
+	if err != nil {
+		return err
+	}
+	var ret []AuditEntry
+	err = loadAudit(cli, 0, func(e *AuditEntry) (bool, error) {
+		touches, err := e.touches(cli, cfg.Path)
+		if err != nil {
+			return false, err
+		}
+		if touches {
+			ret = append(ret, *e)
+		}
+		return a.Limit <= 0 || len(ret) < a.Limit, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if a.Json {
+		buf, err := marshalIndent(ret)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREVISION\tUSER\tHOST\tKEYS\tMESSAGE")
+	for _, e := range ret {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\n", e.ID, e.Revision, e.User, e.Host, e.Keys, e.Message)
+	}
+	return w.Flush()
+}
+
+func (a *AuditArg) Show(args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid params")
+	}
+	cli, err := client.NewClient(a.Dsn)
+	if err != nil {
+		return err
+	}
+	kvs, _, err := cli.GetKVsWithPrefix(auditPrefix + args[0])
+	if err != nil {
+		return err
+	}
+	if len(kvs) == 0 {
+		return errors.Errorf("audit entry %s not found", args[0])
+	}
+	if len(kvs) > 1 {
+		return errors.Errorf("audit entry %s is ambiguous, %d entries match", args[0], len(kvs))
+	}
+	e, err := parseAudit(kvs[0])
+	if err != nil {
+		return err
+	}
+	if err := e.loadChanges(cli); err != nil {
+		return err
+	}
+
+	if a.Json {
+		buf, err := marshalIndent(e)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	fmt.Printf("id:       %s\nrevision: %d\ntime:     %s\nuser:     %s\nhost:     %s\ncommand:  %s\n",
+		e.ID, e.Revision, e.Time.Local().Format(time.RFC3339), e.User, e.Host, e.Command)
+	if e.Message != "" {
+		fmt.Printf("message:  %s\n", e.Message)
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tKEY\tOLD\tNEW")
+	for _, c := range e.Changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Type, c.Key, c.Old, c.New)
+	}
+	return w.Flush()
+}
+
+// touches reports whether the entry changed a key under path, the parts of
+// the changes are only read when the prefix of the entry can't tell
+func (e *AuditEntry) touches(cli *client.Client, path string) (bool, error) {
+	if e.Parts > 0 {
+		if strings.HasPrefix(e.Prefix, path) {
+			return true, nil
+		}
+		if !strings.HasPrefix(path, e.Prefix) {
+			return false, nil
+		}
+	}
+	if err := e.loadChanges(cli); err != nil {
+		return false, err
+	}
+	for _, c := range e.Changes {
+		if strings.HasPrefix(c.Key, path) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// loadChanges reads the parts of the changes once, when they are not inline
+func (e *AuditEntry) loadChanges(cli *client.Client) error {
+	if e.Parts == 0 || e.Changes != nil {
+		return nil
+	}
+	// a part is about 100KB, read a few at a time
+	it := cli.Range(context.Background(), auditChangesPrefix+e.ID+delimiter, 4)
+	changes := []AuditChange{}
+	for it.Next() {
+		for _, kv := range it.Page() {
+			var part []AuditChange
+			if err := jsoniter.Unmarshal([]byte(kv.Value), &part); err != nil {
+				return errors.Errorf("audit entry %s: %s", kv.Key, err.Error())
+			}
+			changes = append(changes, part...)
+		}
+	}
+	if it.Err() != nil {
+		return it.Err()
+	}
+	e.Changes = changes
+	return nil
+}
+
+// auditOps returns the ops recording changes in the audit log, to commit in
+// the same transactions as them. The entry itself is small and goes to every
+// transaction, so its key is created by the first one and last modified by the
+// last one. The changes are inline when few, otherwise they are split in parts
+// which are appended once to the changed ops. There are none when nothing
+// changes.
+func auditOps(changes []Change) (always, parts []clientv3.Op, err error) {
+	if len(changes) == 0 {
+		return nil, nil, nil
+	}
+	now := time.Now().UTC()
+	suffix := make([]byte, 2)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, nil, err
+	}
+	host, _ := os.Hostname()
+	e := AuditEntry{
+		ID:      fmt.Sprintf("%s-%x", now.Format("20060102T150405.000000000Z"), suffix),
+		User:    auditUser,
+		Host:    host,
+		Command: redactDSN(strings.Join(os.Args, " ")),
+		Message: auditMessage,
+		Time:    now,
+	}
+	keys := make([]string, 0, len(changes))
+	auditChanges := make([]AuditChange, 0, len(changes))
+	for _, c := range changes {
+		keys = append(keys, c.Key)
+		auditChanges = append(auditChanges, AuditChange{Type: c.Type, Key: c.Key, Old: valueHash(c.Type != changeAdd, c.Old), New: valueHash(c.Type != changeDelete, c.New)})
+	}
+	e.Keys, e.Prefix = len(changes), commonDir(keys)
+	if len(auditChanges) <= auditInlineChanges {
+		e.Changes = auditChanges
+	}
+	for i := 0; e.Changes == nil && i < len(auditChanges); i += auditPartChanges {
+		end := i + auditPartChanges
+		if end > len(auditChanges) {
+			end = len(auditChanges)
+		}
+		buf, err := jsoniter.Marshal(auditChanges[i:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, clientv3.OpPut(fmt.Sprintf("%s%s/%06d", auditChangesPrefix, e.ID, e.Parts), string(buf)))
+		e.Parts++
+	}
+	buf, err := jsoniter.Marshal(e)
+	if err != nil {
+		return nil, nil, err
+	}
+	return []clientv3.Op{clientv3.OpPut(auditPrefix+e.ID, string(buf))}, parts, nil
+}
+
+// commonDir returns the longest dir, with a trailing delimiter, holding all
+// the keys
+func commonDir(keys []string) string {
+	if len(keys) == 0 {
+		return ""
+	}
+	prefix := keys[0]
+	for _, k := range keys[1:] {
+		for !strings.HasPrefix(k, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix[:strings.LastIndex(prefix, delimiter)+1]
+}
+
+// valueHash returns the first 8 bytes of the sha256 of a value in hex, or ""
+// when there is no value
+func valueHash(exists bool, val string) string {
+	if !exists {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(val))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+var dsnPasswordRe = regexp.MustCompile(`([^\s:/@=]+):\S*@`)
+
+// redactDSN hides the passwords of the dsns in a command line
+func redactDSN(cmdline string) string {
+	return dsnPasswordRe.ReplaceAllString(cmdline, "$1:***@")
+}
+
+// loadAudit calls fn with the audit entries which ended at minRev or later,
+// newest first by id, until fn returns false. The entries are read a page at
+// a time and the older ones are filtered out by the server.
+func loadAudit(cli *client.Client, minRev int64, fn func(e *AuditEntry) (bool, error)) error {
+	it := cli.RangeWithOptions(context.Background(), auditPrefix, client.RangeOptions{
+		PageSize:       100,
+		MinModRevision: minRev,
+		Descend:        true,
+	})
+	for it.Next() {
+		for _, kv := range it.Page() {
+			e, err := parseAudit(kv)
+			if err != nil {
+				logrus.Warnf("skip audit entry %s: %s", kv.Key, err.Error())
+				continue
+			}
+			more, err := fn(e)
+			if err != nil || !more {
+				return err
+			}
+		}
+	}
+	return it.Err()
+}
+
+func parseAudit(kv client.KV) (*AuditEntry, error) {
+	var e AuditEntry
+	if err := jsoniter.Unmarshal([]byte(kv.Value), &e); err != nil {
+		return nil, err
+	}
+	e.Revision = kv.ModRevision
+	e.firstRevision = kv.CreateRevision
+	// entries written before Keys and Prefix existed have their changes inline
+	if e.Parts == 0 && e.Keys == 0 {
+		e.Keys = len(e.Changes)
+	}
+	return &e, nil
+}
+
+// auditAuthor returns the user whose audited change wrote key at rev, cli
+// reads the parts of the changes of the candidate entries
+func auditAuthor(cli *client.Client, entries []AuditEntry, key string, rev int64) (string, error) {
+	for i := range entries {
+		e := &entries[i]
+		if rev < e.firstRevision || rev > e.Revision || e.Parts > 0 && !strings.HasPrefix(key, e.Prefix) {
+			continue
+		}
+		if err := e.loadChanges(cli); err != nil {
+			return "", err
+		}
+		for _, c := range e.Changes {
+			if c.Key == key {
+				return e.User, nil
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactDSN(t *testing.T) {
+	assert.Equal(t, "etcd-tool copy -f root:***@a:2379/x --to=u:***@b:2379,c:2379/y -e localhost:2379/z",
+		redactDSN("etcd-tool copy -f root:p@ss:w0rd@a:2379/x --to=u:secret@b:2379,c:2379/y -e localhost:2379/z"))
+}
+
+func TestAuditAuthor(t *testing.T) {
+	always, parts, err := auditOps([]Change{
+		{Type: changeAdd, Key: "/app/a", New: "1"},
+		{Type: changeDelete, Key: "/app/b", Old: "2"},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, always, 1)
+	assert.Len(t, parts, 0)
+	e, err := parseAudit(client.KV{Key: string(always[0].KeyBytes()), Value: string(always[0].ValueBytes()), CreateRevision: 10, ModRevision: 12})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, e.Keys)
+	assert.Equal(t, "/app/", e.Prefix)
+	assert.Equal(t, "", e.Changes[0].Old)
+	assert.Equal(t, valueHash(true, "1"), e.Changes[0].New)
+	assert.Equal(t, "", e.Changes[1].New)
+
+	entries := []AuditEntry{*e}
+	author, err := auditAuthor(nil, entries, "/app/b", 11)
+	assert.Nil(t, err)
+	assert.Equal(t, auditUser, author)
+	author, _ = auditAuthor(nil, entries, "/app/b", 13)
+	assert.Equal(t, "", author)
+	author, _ = auditAuthor(nil, entries, "/app/c", 11)
+	assert.Equal(t, "", author)
+}
+
+func TestAuditOps_Large(t *testing.T) {
+	// far more keys than a transaction allows, each one written once
+	var changes []Change
+	for i := 0; i < 20000; i++ {
+		changes = append(changes, Change{Type: changeUpdate, Key: fmt.Sprintf("/app/dir%d/key%05d", i%3, i), Old: "a", New: "b"})
+	}
+	always, parts, err := auditOps(changes)
+	assert.Nil(t, err)
+	assert.Len(t, always, 1)
+	assert.Len(t, parts, 20)
+	assert.True(t, len(changes)+len(parts) > client.DefaultMaxTxnOps)
+
+	// the entry goes to every transaction, it must stay small
+	assert.True(t, len(always[0].ValueBytes()) < 1024)
+	e, err := parseAudit(client.KV{Key: string(always[0].KeyBytes()), Value: string(always[0].ValueBytes())})
+	assert.Nil(t, err)
+	assert.Equal(t, 20000, e.Keys)
+	assert.Equal(t, "/app/", e.Prefix)
+	assert.Equal(t, 20, e.Parts)
+	assert.Nil(t, e.Changes)
+
+	// Commit fits several parts in a transaction, but never one over the limit
+	for _, op := range parts {
+		assert.True(t, strings.HasPrefix(string(op.KeyBytes()), auditChangesPrefix+e.ID+delimiter))
+		assert.True(t, len(op.ValueBytes()) < client.MaxTxnBytes/4)
+	}
+}
+
+func TestCommonDir(t *testing.T) {
+	assert.Equal(t, "/app/", commonDir([]string{"/app/a", "/app/ab/c"}))
+	assert.Equal(t, "/app/ab/", commonDir([]string{"/app/ab/c"}))
+	assert.Equal(t, "/", commonDir([]string{"/app/a", "/b"}))
+}
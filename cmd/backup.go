@@ -0,0 +1,220 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// backupCmd represents the backup command
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "save every key under a path to an archive file",
+	Long: `Save every key under the dsn path, with its revisions, version and lease
+ttl, to a checksummed archive which can be loaded back with "restore".
+
+Example:
+  etcd-tool backup -e localhost:2379/my_project -o my_project.json.gz`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := backupArg.Run(); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var backupArg BackupArg
+
+type BackupArg struct {
+	Dsn    string
+	Output string
+	Gzip   bool
+}
+
+const (
+	archiveFormat  = "etcd-tool-backup"
+	archiveVersion = 1
+)
+
+// Archive is the backup file format. Keys are relative to Path so an archive
+// can be restored under another path, Checksum is the sha256 of the json
+// encoded Kvs.
+type Archive struct {
+	Format    string      `json:"format"`
+	Version   int         `json:"version"`
+	Path      string      `json:"path"`
+	Revision  int64       `json:"revision"`
+	CreatedAt time.Time   `json:"created_at"`
+	Checksum  string      `json:"checksum"`
+	Kvs       []ArchiveKV `json:"kvs"`
+}
+
+type ArchiveKV struct {
+	Key            string `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision"`
+	ModRevision    int64  `json:"mod_revision"`
+	Version        int64  `json:"version"`
+	TTL            int64  `json:"ttl,omitempty"`
+}
+
+func init() {
+	RootCmd.AddCommand(backupCmd)
+
+	backupCmd.Flags().StringVarP(&backupArg.Dsn, "etcd", "e", "", "etcd address")
+	backupCmd.Flags().StringVarP(&backupArg.Output, "output", "o", "", "archive file, - for stdout")
+	backupCmd.Flags().BoolVarP(&backupArg.Gzip, "gzip", "z", false, "compress the archive, default when the file ends with .gz")
+}
+
+func (b *BackupArg) Run() error {
+	cfg := client.ParseDSN(b.Dsn)
+	if cfg == nil || b.Output == "" {
+		return errors.New("invalid params")
+	}
+	cli, err := client.NewClient(b.Dsn)
+	if err != nil {
+		return err
+	}
+	kvs, rev, err := cli.GetKVsWithPrefix(cfg.Path)
+	if err != nil {
+		return err
+	}
+
+	archive := Archive{
+		Format:    archiveFormat,
+		Version:   archiveVersion,
+		Path:      cfg.Path,
+		Revision:  rev,
+		CreatedAt: time.Now(),
+		Kvs:       make([]ArchiveKV, 0, len(kvs)),
+	}
+	for _, kv := range kvs {
+		akv := ArchiveKV{
+			Key:            strings.TrimPrefix(kv.Key, cfg.Path),
+			Value:          []byte(kv.Value),
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+			Version:        kv.Version,
+		}
+		if kv.Lease != 0 {
+			ttl, err := cli.LeaseTTL(kv.Lease)
+			if err != nil {
+				return err
+			}
+			// the key is gone with its lease, keep the shortest ttl possible
+			if ttl <= 0 {
+				ttl = 1
+			}
+			akv.TTL = ttl
+		}
+		archive.Kvs = append(archive.Kvs, akv)
+	}
+	if archive.Checksum, err = archive.sum(); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+	if b.Gzip || strings.HasSuffix(b.Output, ".gz") {
+		zbuf := &bytes.Buffer{}
+		zw := gzip.NewWriter(zbuf)
+		if _, err := zw.Write(buf); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		buf = zbuf.Bytes()
+	}
+	if b.Output == "-" {
+		_, err = os.Stdout.Write(buf)
+	} else {
+		err = ioutil.WriteFile(b.Output, buf, 0600)
+	}
+	if err != nil {
+		return err
+	}
+	logrus.Infof("backup %d key of %s at revision %d", len(archive.Kvs), cfg.Path, rev)
+	return nil
+}
+
+func (a *Archive) sum() (string, error) {
+	buf, err := json.Marshal(a.Kvs)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(buf)), nil
+}
+
+// readArchive loads and verifies an archive, gzipped or not
+func readArchive(name string) (*Archive, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > 2 && buf[0] == 0x1f && buf[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		if buf, err = ioutil.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+
+	archive := &Archive{}
+	if err := json.Unmarshal(buf, archive); err != nil {
+		return nil, err
+	}
+	if archive.Format != archiveFormat {
+		return nil, errors.Errorf("%s is not a backup archive", name)
+	}
+	if archive.Version > archiveVersion {
+		return nil, errors.Errorf("unsupported archive version %d, upgrade etcd-tool", archive.Version)
+	}
+	sum, err := archive.sum()
+	if err != nil {
+		return nil, err
+	}
+	if sum != archive.Checksum {
+		return nil, errors.Errorf("checksum mismatch, archive is corrupted: got %s, expect %s", sum, archive.Checksum)
+	}
+	return archive, nil
+}
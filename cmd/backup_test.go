@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadArchive(t *testing.T) {
+	archive := Archive{
+		Format:  archiveFormat,
+		Version: archiveVersion,
+		Path:    "/test/",
+		Kvs: []ArchiveKV{
+			{Key: "redis/address", Value: []byte("localhost:6379"), Version: 1},
+			{Key: "redis/bin", Value: []byte{0xff, 0x00}, TTL: 10},
+		},
+	}
+	var err error
+	archive.Checksum, err = archive.sum()
+	assert.Nil(t, err)
+
+	f, err := ioutil.TempFile("", "archive")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	buf, _ := json.Marshal(archive)
+	f.Write(buf)
+	f.Close()
+
+	got, err := readArchive(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, archive.Kvs, got.Kvs)
+
+	archive.Kvs[0].Value = []byte("localhost:6380")
+	buf, _ = json.Marshal(archive)
+	ioutil.WriteFile(f.Name(), buf, 0600)
+	_, err = readArchive(f.Name())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-errors/errors"
+	"github.com/json-iterator/go"
+	"github.com/magiconair/properties"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	formatJSON       = "json"
+	formatYAML       = "yaml"
+	formatTOML       = "toml"
+	formatProperties = "properties"
+	formatDotenv     = "dotenv"
+)
+
+// confFormat picks the format of a config file by its extension, json is
+// the default
+func confFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	case ".properties":
+		return formatProperties
+	case ".env":
+		return formatDotenv
+	default:
+		return formatJSON
+	}
+}
+
+// loadConf reads a config file into the tree fed to parseKeyValue, format
+// may be empty to detect it from the file extension
+func loadConf(path, format string) (map[string]interface{}, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = confFormat(path)
+	}
+	confMap, err := parseConf(buf, format)
+	if err != nil {
+		return nil, errors.Errorf("%s: %s", path, err.Error())
+	}
+	return confMap, nil
+}
+
+// appendMarker as the first element of an overlay array appends the rest of
+// it to the base array instead of replacing it
+const appendMarker = "$append"
+
+// loadConfs loads the files in order and deep merges each one over the
+// previous ones, see mergeConf. Dirs are expanded to the config files they
+// contain, sorted by name.
+func loadConfs(paths []string, format string, force bool) (map[string]interface{}, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			name := info.Name()
+			if info.IsDir() || strings.HasPrefix(name, ".") && name != ".env" {
+				continue
+			}
+			if format == "" && confFormat(name) == formatJSON && strings.ToLower(filepath.Ext(name)) != ".json" {
+				continue
+			}
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no configure file")
+	}
+
+	var merged map[string]interface{}
+	for i, file := range files {
+		confMap, err := loadConf(file, format)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			dropAppendMarkers(confMap)
+			merged = confMap
+			continue
+		}
+		if err := mergeConf(merged, confMap, "", force); err != nil {
+			return nil, errors.Errorf("%s: %s", file, err.Error())
+		}
+	}
+	return merged, nil
+}
+
+// dropAppendMarkers removes appendMarker from the arrays of the first file,
+// there is nothing to append them to
+func dropAppendMarkers(conf map[string]interface{}) {
+	for k, v := range conf {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			dropAppendMarkers(val)
+		case []interface{}:
+			if len(val) > 0 && val[0] == appendMarker {
+				conf[k] = val[1:]
+			}
+		}
+	}
+}
+
+// mergeConf merges overlay into base: maps are merged recursively, null
+// deletes the key (it becomes "" which put deletes), arrays replace the base
+// array unless they start with appendMarker, and anything else replaces the
+// base value. A map replacing a value or the other way round is an error
+// unless force is set.
+func mergeConf(base, overlay map[string]interface{}, path string, force bool) error {
+	for k, v := range overlay {
+		keyPath := path + delimiter + k
+		old, exists := base[k]
+		switch val := v.(type) {
+		case nil:
+			base[k] = ""
+			continue
+		case map[string]interface{}:
+			oldMap, ok := old.(map[string]interface{})
+			if !ok {
+				if exists && old != "" && !force {
+					return errors.Errorf("%s: can't override value %v with a map, use --force", keyPath, old)
+				}
+				// merge into an empty map so nested null and appendMarker apply
+				oldMap = map[string]interface{}{}
+				base[k] = oldMap
+			}
+			if err := mergeConf(oldMap, val, keyPath, force); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			if len(val) > 0 && val[0] == appendMarker {
+				oldArr, _ := old.([]interface{})
+				if exists && oldArr == nil && !force {
+					return errors.Errorf("%s: can't append to %v, not an array", keyPath, old)
+				}
+				base[k] = append(append([]interface{}{}, oldArr...), val[1:]...)
+				continue
+			}
+		}
+		if _, ok := old.(map[string]interface{}); ok && !force {
+			return errors.Errorf("%s: can't override a map with value %v, use --force", keyPath, v)
+		}
+		base[k] = v
+	}
+	return nil
+}
+
+// interpolateRe matches ${NAME}, ${NAME:-default} and ${file:/path}, $${ is
+// kept as a literal ${
+var interpolateRe = regexp.MustCompile(`\$?\$\{([^}:]*)(:-?)?([^}]*)\}`)
+
+// interpolate expands the expressions of interpolateRe in every string value
+// of conf in place, path locates conf in the document for the errors. Files
+// are read without their trailing newline.
+func interpolate(conf interface{}, path string) (interface{}, error) {
+	switch val := conf.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			expanded, err := interpolate(v, path+delimiter+k)
+			if err != nil {
+				return nil, err
+			}
+			val[k] = expanded
+		}
+		return val, nil
+	case []interface{}:
+		for i, v := range val {
+			expanded, err := interpolate(v, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			val[i] = expanded
+		}
+		return val, nil
+	case string:
+		var err error
+		ret := interpolateRe.ReplaceAllStringFunc(val, func(expr string) string {
+			if err != nil {
+				return ""
+			}
+			if strings.HasPrefix(expr, "$$") {
+				return expr[1:]
+			}
+			m := interpolateRe.FindStringSubmatch(expr)
+			name, sep, rest := m[1], m[2], m[3]
+			switch {
+			case name == "file" && sep == ":":
+				buf, e := ioutil.ReadFile(rest)
+				if e != nil {
+					err = errors.Errorf("%s: %s", path, e.Error())
+					return ""
+				}
+				return strings.TrimRight(string(buf), "\r\n")
+			case name == "" || sep == ":":
+				err = errors.Errorf("%s: invalid expression %s", path, expr)
+				return ""
+			}
+			if env, ok := os.LookupEnv(name); ok {
+				return env
+			}
+			if sep == ":-" {
+				return rest
+			}
+			err = errors.Errorf("%s: environment variable %s is not set", path, name)
+			return ""
+		})
+		if err != nil {
+			return nil, err
+		}
+		return ret, nil
+	default:
+		return val, nil
+	}
+}
+
+func parseConf(buf []byte, format string) (map[string]interface{}, error) {
+	confMap := map[string]interface{}{}
+	switch format {
+	case formatJSON:
+		if err := jsoniter.Unmarshal(buf, &confMap); err != nil {
+			return nil, err
+		}
+		return confMap, nil
+	case formatYAML:
+		var conf interface{}
+		if err := yaml.Unmarshal(buf, &conf); err != nil {
+			return nil, err
+		}
+		if conf == nil {
+			return confMap, nil
+		}
+		normalized, ok := normalizeConf(conf).(map[string]interface{})
+		if !ok {
+			return nil, errors.New("top level must be a map")
+		}
+		return normalized, nil
+	case formatTOML:
+		tree, err := toml.LoadBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		return normalizeConf(tree.ToMap()).(map[string]interface{}), nil
+	case formatProperties:
+		props, err := properties.Load(buf, properties.UTF8)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range props.Keys() {
+			v, _ := props.Get(k)
+			if err := setPath(confMap, strings.Split(k, "."), v); err != nil {
+				return nil, err
+			}
+		}
+		return confMap, nil
+	case formatDotenv:
+		return parseDotenv(buf)
+	default:
+		return nil, errors.Errorf("unknown format %s", format)
+	}
+}
+
+// normalizeConf converts what yaml and toml decode into the types produced
+// by json: map[interface{}]interface{} becomes map[string]interface{} with
+// stringified keys, typed slices become []interface{}, times and toml local
+// dates become strings
+func normalizeConf(conf interface{}) interface{} {
+	switch val := conf.(type) {
+	case map[interface{}]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			ret[fmt.Sprint(k)] = normalizeConf(v)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			ret[k] = normalizeConf(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, v := range val {
+			ret[i] = normalizeConf(v)
+		}
+		return ret
+	case []map[string]interface{}:
+		ret := make([]interface{}, len(val))
+		for i, v := range val {
+			ret[i] = normalizeConf(v)
+		}
+		return ret
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return val.String()
+	default:
+		return val
+	}
+}
+
+// setPath sets value at the nested path of confMap, creating the maps on the
+// way
+func setPath(confMap map[string]interface{}, path []string, value interface{}) error {
+	for i, p := range path[:len(path)-1] {
+		next, ok := confMap[p]
+		if !ok {
+			next = map[string]interface{}{}
+			confMap[p] = next
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("key %s is both a value and a dir", strings.Join(path[:i+1], "."))
+		}
+		confMap = m
+	}
+	last := path[len(path)-1]
+	if _, ok := confMap[last].(map[string]interface{}); ok {
+		return errors.Errorf("key %s is both a value and a dir", strings.Join(path, "."))
+	}
+	confMap[last] = value
+	return nil
+}
+
+// parseDotenv reads KEY=VALUE lines, keys are kept flat. Blank lines,
+// comments and the export keyword are skipped, quoted values are unquoted.
+func parseDotenv(buf []byte) (map[string]interface{}, error) {
+	confMap := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		idx := strings.Index(line, "=")
+		if idx <= 0 {
+			return nil, errors.Errorf("line %d: expect KEY=VALUE", n)
+		}
+		key, val := strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+		switch {
+		case strings.HasPrefix(val, `"`):
+			v, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, errors.Errorf("line %d: %s", n, err.Error())
+			}
+			val = v
+		case strings.HasPrefix(val, "'") && strings.HasSuffix(val, "'") && len(val) > 1:
+			val = val[1 : len(val)-1]
+		default:
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = strings.TrimSpace(val[:i])
+			}
+		}
+		confMap[key] = val
+	}
+	return confMap, scanner.Err()
+}
+
+// flattenConf returns the keys parseKeyValue would write for confMap,
+// relative to the config root
+func flattenConf(confMap map[string]interface{}) (map[string]string, error) {
+	p := PutArg{Kvs: map[string]string{}}
+	if err := p.parseKeyValue(confMap, delimiter); err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(p.Kvs))
+	for k, v := range p.Kvs {
+		kvs[strings.TrimPrefix(k, delimiter)] = v
+	}
+	return kvs, nil
+}
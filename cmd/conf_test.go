@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConf(t *testing.T) {
+	expect := map[string]string{
+		"redis/address": "localhost:6379",
+		"redis/db":      "3",
+		"redis/enabled": "true",
+		"redis/hosts":   `["a","b"]`,
+	}
+	for format, conf := range map[string]string{
+		formatJSON: `{"redis": {"address": "localhost:6379", "db": 3, "enabled": true, "hosts": ["a", "b"]}}`,
+		formatYAML: `
+redis:
+  address: localhost:6379
+  db: 3
+  enabled: true
+  hosts: [a, b]
+`,
+		formatTOML: `
+[redis]
+address = "localhost:6379"
+db = 3
+enabled = true
+hosts = ["a", "b"]
+`,
+	} {
+		confMap, err := parseConf([]byte(conf), format)
+		assert.Nil(t, err, format)
+		kvs, err := flattenConf(confMap)
+		assert.Nil(t, err, format)
+		assert.Equal(t, expect, kvs, format)
+	}
+
+	confMap, err := parseConf([]byte("gray:\n  1: [{id: 2}]\n"), formatYAML)
+	assert.Nil(t, err)
+	kvs, err := flattenConf(confMap)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"gray/1": `[{"id":2}]`}, kvs)
+
+	confMap, err = parseConf([]byte("[[servers]]\nname = \"a\"\n[[servers]]\nname = \"b\"\n"), formatTOML)
+	assert.Nil(t, err)
+	kvs, err = flattenConf(confMap)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"servers": `[{"name":"a"},{"name":"b"}]`}, kvs)
+}
+
+func TestParseProperties(t *testing.T) {
+	confMap, err := parseConf([]byte("redis.address = localhost:6379\nredis.db=3\n# comment\nname=test\n"), formatProperties)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"redis": map[string]interface{}{"address": "localhost:6379", "db": "3"},
+		"name":  "test",
+	}, confMap)
+
+	_, err = parseConf([]byte("redis=1\nredis.db=3\n"), formatProperties)
+	assert.NotNil(t, err)
+}
+
+func TestParseDotenv(t *testing.T) {
+	confMap, err := parseConf([]byte(`
+# comment
+export REDIS_ADDRESS=localhost:6379
+REDIS_PREFIX="a b\n"
+SECRET='x#y'
+DB=3 # comment
+`), formatDotenv)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"REDIS_ADDRESS": "localhost:6379",
+		"REDIS_PREFIX":  "a b\n",
+		"SECRET":        "x#y",
+		"DB":            "3",
+	}, confMap)
+
+	_, err = parseConf([]byte("NOVALUE\n"), formatDotenv)
+	assert.NotNil(t, err)
+}
+
+func TestMergeConf(t *testing.T) {
+	parse := func(s string) map[string]interface{} {
+		confMap, err := parseConf([]byte(s), formatJSON)
+		assert.Nil(t, err)
+		return confMap
+	}
+	base := parse(`{"redis": {"address": "localhost:6379", "db": 1, "hosts": ["a"], "pool": {"size": 1}}, "name": "base"}`)
+	err := mergeConf(base, parse(`{"redis": {"db": 2, "hosts": ["$append", "b"], "pool": null, "new": {"x": null, "y": 1}}}`), "", false)
+	assert.Nil(t, err)
+	assert.Equal(t, parse(`{"redis": {"address": "localhost:6379", "db": 2, "hosts": ["a", "b"], "pool": "", "new": {"x": "", "y": 1}}, "name": "base"}`), base)
+
+	err = mergeConf(base, parse(`{"redis": {"hosts": ["c"]}}`), "", false)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"c"}, base["redis"].(map[string]interface{})["hosts"])
+
+	err = mergeConf(base, parse(`{"name": {"first": "a"}}`), "", false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "/name")
+	err = mergeConf(base, parse(`{"redis": "localhost"}`), "", false)
+	assert.NotNil(t, err)
+	err = mergeConf(base, parse(`{"redis": "localhost"}`), "", true)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", base["redis"])
+}
+
+func TestLoadConfs_FirstAppend(t *testing.T) {
+	f, err := ioutil.TempFile("", "conf*.json")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"hosts": ["$append", "a"], "redis": {"hosts": ["$append"]}, "name": null}`)
+	f.Close()
+
+	confMap, err := loadConfs([]string{f.Name()}, "", false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"hosts": []interface{}{"a"},
+		"redis": map[string]interface{}{"hosts": []interface{}{}},
+		"name":  nil,
+	}, confMap)
+}
+
+func TestInterpolate(t *testing.T) {
+	os.Setenv("ETCD_TOOL_TEST_HOST", "redis.local")
+	os.Unsetenv("ETCD_TOOL_TEST_UNSET")
+	f, err := ioutil.TempFile("", "secret")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("s3cret\n")
+	f.Close()
+
+	confMap, err := parseConf([]byte(`{
+		"redis": {
+			"address": "${ETCD_TOOL_TEST_HOST}:${ETCD_TOOL_TEST_UNSET:-6379}",
+			"password": "${file:`+f.Name()+`}",
+			"hosts": [1, "$${ETCD_TOOL_TEST_HOST}"]
+		}
+	}`), formatJSON)
+	assert.Nil(t, err)
+	_, err = interpolate(confMap, "")
+	assert.Nil(t, err)
+	redis := confMap["redis"].(map[string]interface{})
+	assert.Equal(t, "redis.local:6379", redis["address"])
+	assert.Equal(t, "s3cret", redis["password"])
+	assert.Equal(t, "${ETCD_TOOL_TEST_HOST}", redis["hosts"].([]interface{})[1])
+
+	confMap, err = parseConf([]byte(`{"redis": {"hosts": ["a", "${ETCD_TOOL_TEST_UNSET}"]}}`), formatJSON)
+	assert.Nil(t, err)
+	_, err = interpolate(confMap, "")
+	assert.NotNil(t, err)
+	assert.Equal(t, "/redis/hosts[1]: environment variable ETCD_TOOL_TEST_UNSET is not set", err.Error())
+}
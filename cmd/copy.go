@@ -15,13 +15,23 @@
 package cmd
 
 import (
+	"context"
+	"crypto/sha1"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/go-errors/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/Guazi-inc/etcd-tool/client"
 )
 
 // copyCmd represents the copy command
@@ -44,10 +54,16 @@ to quickly create a Cobra application.`,
 var copyArg CopyArg
 
 type CopyArg struct {
-	From    string
-	To      string
-	FromCfg *client.Config
-	ToCfg   *client.Config
+	From      string
+	To        string
+	FromCfg   *client.Config
+	ToCfg     *client.Config
+	Sync      bool
+	Exclude   []string
+	Watch     bool
+	StateFile string
+	PageSize  int
+	Lock      LockOptions
 }
 
 func init() {
@@ -65,6 +81,12 @@ func init() {
 
 	copyCmd.Flags().StringVarP(&copyArg.From, "from", "f", "", "source address and dir (host:port/path)")
 	copyCmd.Flags().StringVarP(&copyArg.To, "to", "t", "", "destination address and dir (host:port/path)")
+	copyCmd.Flags().BoolVar(&copyArg.Sync, "sync", false, "delete destination keys missing at the source and skip identical ones")
+	copyCmd.Flags().StringSliceVar(&copyArg.Exclude, "exclude", nil, "glob of destination keys, relative to its dir, never deleted by --sync")
+	copyCmd.Flags().BoolVar(&copyArg.Watch, "watch", false, "keep replicating changes after the copy")
+	copyCmd.Flags().StringVar(&copyArg.StateFile, "state-file", "", "file keeping the last replicated revision, default is a key in the destination")
+	copyCmd.Flags().IntVar(&copyArg.PageSize, "page-size", client.DefaultPageSize, "keys read per request")
+	addLockFlags(copyCmd, &copyArg.Lock)
 }
 
 func (c *CopyArg) Run() error {
@@ -73,33 +95,247 @@ func (c *CopyArg) Run() error {
 	if c.FromCfg == nil || c.ToCfg == nil {
 		return errors.New("invalid params")
 	}
+	for _, pattern := range c.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid exclude pattern %s: %s", pattern, err.Error())
+		}
+	}
 
 	fromCli, err := client.NewClient(c.From)
 	if err != nil {
 		return err
 	}
-	kvs, err := fromCli.GetWithPrefix(c.FromCfg.Path)
+	toCli, err := client.NewClient(c.To)
 	if err != nil {
 		return err
 	}
-	toCli, err := client.NewClient(c.To)
+	ctx, stop := signalContext()
+	defer stop()
+	// --watch holds the lock of the destination for as long as it runs
+	unlock, err := c.Lock.lock(ctx, toCli, c.ToCfg.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if c.Watch {
+		return c.watch(ctx, fromCli, toCli)
+	}
+	_, err = c.copy(ctx, fromCli, toCli, c.Sync)
+	return err
+}
+
+// copy copies the whole source dir and returns the revision it was read at,
+// see sync for deleteMissing
+func (c *CopyArg) copy(ctx context.Context, fromCli, toCli *client.Client, deleteMissing bool) (int64, error) {
+	kvs, rev, err := fromCli.GetWithPrefixPaged(ctx, c.FromCfg.Path, c.PageSize)
+	if err != nil {
+		return 0, err
+	}
+	return rev, c.sync(ctx, toCli, dropReserved(kvs), deleteMissing)
+}
+
+// sync writes kvs to the destination dir in as few transactions as possible,
+// with deleteMissing it also deletes the destination keys missing from kvs
+func (c *CopyArg) sync(ctx context.Context, toCli *client.Client, kvs map[string]string, deleteMissing bool) error {
+	before, rev, err := toCli.GetWithPrefixPaged(ctx, c.ToCfg.Path, c.PageSize)
 	if err != nil {
 		return err
 	}
-	var errKvs []string
+	after := c.syncAfter(before, kvs, deleteMissing)
+
+	plan := Plan{Changes: diffKvs(before, after), Rev: rev}
+	audit, parts, err := auditOps(plan.Changes)
+	if err != nil {
+		return err
+	}
+	if _, err := toCli.CommitCtx(ctx, append(plan.Ops(), parts...), client.CommitOptions{Rev: rev, Always: audit}); err != nil {
+		return err
+	}
+	if !deleteMissing {
+		fmt.Printf("copy %d key, all success\n", len(kvs))
+		return nil
+	}
+	created, updated := plan.Count(changeAdd), plan.Count(changeUpdate)
+	fmt.Printf("sync %d key, %d created, %d updated, %d deleted, %d unchanged\n",
+		len(kvs), created, updated, plan.Count(changeDelete), len(kvs)-created-updated)
+	return nil
+}
+
+// syncAfter returns the destination keys once the source kvs are written over
+// before. deleteMissing drops the keys missing from kvs, except for excluded
+// and reserved ones.
+func (c *CopyArg) syncAfter(before, kvs map[string]string, deleteMissing bool) map[string]string {
+	after := map[string]string{}
+	for k, v := range before {
+		if !deleteMissing || c.excluded(k) || isReserved(k) {
+			after[k] = v
+		}
+	}
 	for k, v := range kvs {
-		nk := fmt.Sprintf("%s%s", c.ToCfg.Path, strings.TrimPrefix(k, c.FromCfg.Path))
-		if err = toCli.Put(nk, v); err != nil {
-			errKvs = append(errKvs, k)
+		after[c.destKey(k)] = v
+	}
+	return after
+}
+
+// destKey rewrites a source key to the destination dir
+func (c *CopyArg) destKey(key string) string {
+	return fmt.Sprintf("%s%s", c.ToCfg.Path, strings.TrimPrefix(key, c.FromCfg.Path))
+}
+
+// excluded reports whether a destination key, or one of its parent dirs,
+// matches an --exclude pattern
+func (c *CopyArg) excluded(key string) bool {
+	if isReserved(key) {
+		return true
+	}
+	return matchGlob(c.Exclude, strings.TrimPrefix(key, c.ToCfg.Path))
+}
+
+// matchGlob reports whether a relative key, or one of its parent dirs,
+// matches one of the path.Match patterns
+func matchGlob(patterns []string, key string) bool {
+	key = strings.TrimPrefix(key, delimiter)
+	for _, pattern := range patterns {
+		pattern = strings.TrimPrefix(pattern, delimiter)
+		for k := key; k != ""; k = path.Dir(k) {
+			if ok, _ := path.Match(pattern, k); ok {
+				return true
+			}
+			if !strings.Contains(k, delimiter) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// watch replicates the source dir continuously. The last applied revision is
+// stored with every batch so a restart resumes from it, a full copy is only
+// done on the first run or when that revision has been compacted. The copy
+// after a compaction deletes like --sync, the keys deleted in the compacted
+// revisions are found no other way. It returns once ctx is done.
+func (c *CopyArg) watch(ctx context.Context, fromCli, toCli *client.Client) error {
+	rev, err := c.loadRev(ctx, toCli)
+	if err != nil {
+		return err
+	}
+	resync := false
+	for {
+		if rev == 0 {
+			if rev, err = c.copy(ctx, fromCli, toCli, c.Sync || resync); err != nil {
+				return err
+			}
+			if err = c.saveRev(ctx, toCli, nil, rev); err != nil {
+				return err
+			}
+		}
+		logrus.Infof("watch %s from revision %d", c.FromCfg.Path, rev+1)
+		rev, err = c.replay(ctx, fromCli, toCli, rev)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == rpctypes.ErrCompacted {
+			logrus.Warnf("revision %d has been compacted, sync again", rev+1)
+			rev, resync = 0, true
+			continue
+		}
+		logrus.Errorf("watch got err: %s, reconnect", err.Error())
+		time.Sleep(time.Second)
+	}
+}
+
+// replay applies the source events after rev until the watch fails, and
+// returns the last applied revision
+func (c *CopyArg) replay(ctx context.Context, fromCli, toCli *client.Client, rev int64) (int64, error) {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+	wc := fromCli.Watch(ctx, c.FromCfg.Path, clientv3.WithPrefix(), clientv3.WithRev(rev+1), clientv3.WithPrevKV())
+	for wresp := range wc {
+		if err := wresp.Err(); err != nil {
+			return rev, err
+		}
+		if len(wresp.Events) == 0 {
+			continue
+		}
+		// a key can only appear once per txn, keep its last event
+		var keys []string
+		ops := map[string]clientv3.Op{}
+		for _, ev := range wresp.Events {
+			k := string(ev.Kv.Key)
+			if isReserved(k) {
+				continue
+			}
+			nk := c.destKey(k)
+			if _, ok := ops[nk]; !ok {
+				keys = append(keys, nk)
+			}
+			if ev.Type == mvccpb.DELETE {
+				ops[nk] = clientv3.OpDelete(nk)
+			} else {
+				ops[nk] = clientv3.OpPut(nk, string(ev.Kv.Value))
+			}
+		}
+		var batch []clientv3.Op
+		for _, k := range keys {
+			batch = append(batch, ops[k])
 		}
+		last := wresp.Events[len(wresp.Events)-1].Kv.ModRevision
+		if err := c.saveRev(ctx, toCli, batch, last); err != nil {
+			return rev, err
+		}
+		rev = last
+		logrus.Infof("replicated %d key at revision %d", len(batch), rev)
 	}
-	kvsInfo := fmt.Sprintf("copy %d key,", len(kvs))
-	if len(errKvs) > 0 {
-		kvsInfo += fmt.Sprintf(" %d fail", len(errKvs))
+	return rev, errors.New("watch closed")
+}
+
+// stateKey is where the last replicated revision is kept in the destination
+func (c *CopyArg) stateKey() string {
+	sum := sha1.Sum([]byte(c.FromCfg.Addrs + c.FromCfg.Path + " " + c.ToCfg.Path))
+	return fmt.Sprintf("%scopy/%x", reservedPrefix, sum[:8])
+}
+
+func (c *CopyArg) loadRev(ctx context.Context, toCli *client.Client) (int64, error) {
+	var val string
+	if c.StateFile != "" {
+		bytes, err := ioutil.ReadFile(c.StateFile)
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		val = strings.TrimSpace(string(bytes))
 	} else {
-		kvsInfo += " all success"
+		v, err := toCli.GetCtx(ctx, c.stateKey(), client.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		val = v
+	}
+	if val == "" {
+		return 0, nil
 	}
-	fmt.Println(kvsInfo)
+	return strconv.ParseInt(val, 10, 64)
+}
 
-	return nil
+// saveRev applies ops and records rev, in the same transaction when the
+// revision is kept in the destination. Replayed changes are not audited, a
+// replica would grow the audit log without end, only the copies are.
+func (c *CopyArg) saveRev(ctx context.Context, toCli *client.Client, ops []clientv3.Op, rev int64) error {
+	val := strconv.FormatInt(rev, 10)
+	if c.StateFile == "" {
+		ops = append(ops, clientv3.OpPut(c.stateKey(), val))
+	}
+	if _, err := toCli.CommitCtx(ctx, ops, client.CommitOptions{}); err != nil {
+		return err
+	}
+	if c.StateFile == "" {
+		return nil
+	}
+	tmp := c.StateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(val), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.StateFile)
 }
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyArg_excluded(t *testing.T) {
+	c := CopyArg{
+		FromCfg: &client.Config{Path: "/from/"},
+		ToCfg:   &client.Config{Path: "/to/"},
+		Exclude: []string{"locks", "/redis/*_local", "*.bak"},
+	}
+	assert.Equal(t, "/to/redis/address", c.destKey("/from/redis/address"))
+	assert.True(t, c.excluded("/to/locks"))
+	assert.True(t, c.excluded("/to/locks/a/b"))
+	assert.True(t, c.excluded("/to/redis/address_local"))
+	assert.True(t, c.excluded("/to/conf.bak"))
+	assert.False(t, c.excluded("/to/redis/address"))
+	assert.False(t, c.excluded("/to/locks2"))
+	assert.False(t, c.excluded("/to/redis/conf.bak"))
+}
+
+func TestCopyArg_syncAfter(t *testing.T) {
+	c := CopyArg{
+		FromCfg: &client.Config{Path: "/"},
+		ToCfg:   &client.Config{Path: "/"},
+		Exclude: []string{"locks"},
+	}
+	before := map[string]string{"/a": "1", "/gone": "1", "/locks": "l", "/_etcd_tool/copy/x": "7"}
+	kvs := map[string]string{"/a": "2"}
+	assert.Equal(t, map[string]string{"/a": "2", "/gone": "1", "/locks": "l", "/_etcd_tool/copy/x": "7"}, c.syncAfter(before, kvs, false))
+	assert.Equal(t, map[string]string{"/a": "2", "/locks": "l", "/_etcd_tool/copy/x": "7"}, c.syncAfter(before, kvs, true))
+}
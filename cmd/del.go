@@ -17,6 +17,7 @@ package cmd
 import (
 	"errors"
 
+	"github.com/coreos/etcd/clientv3"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/Guazi-inc/etcd-tool/client"
@@ -43,6 +44,7 @@ type DelArg struct {
 	Dsn    string
 	Key    string
 	Prefix bool
+	Lock   LockOptions
 }
 
 var delArg DelArg
@@ -61,6 +63,7 @@ func init() {
 	// delCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	delCmd.Flags().StringVarP(&delArg.Dsn, "etcd", "e", "", "etcd address")
 	delCmd.Flags().BoolVarP(&delArg.Prefix, "prefix", "p", false, "with prefix")
+	addLockFlags(delCmd, &delArg.Lock)
 
 }
 
@@ -73,8 +76,50 @@ func (d *DelArg) Run(args []string) error {
 	if err != nil {
 		return err
 	}
+	ctx, stop := signalContext()
+	defer stop()
+	unlock, err := d.Lock.lock(ctx, cli, d.Key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// read what is deleted for the audit log, the guard keeps it accurate
+	var before map[string]string
+	var rev int64
+	var opts []clientv3.OpOption
+	commitOpts := client.CommitOptions{}
 	if d.Prefix {
-		return cli.DeleteWithPrefix(d.Key)
+		if before, rev, err = cli.GetWithPrefixCtx(ctx, d.Key, client.GetOptions{}); err != nil {
+			return err
+		}
+		opts = append(opts, clientv3.WithPrefix())
+		commitOpts.GuardPrefix = d.Key
+	} else {
+		kv, err := cli.GetKVCtx(ctx, d.Key, client.GetOptions{})
+		if err == client.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		before = map[string]string{d.Key: kv.Value}
+		rev = kv.ModRevision
+		commitOpts.GuardKeys = []string{d.Key}
+	}
+	changes := diffKvs(before, map[string]string{})
+	if len(changes) == 0 {
+		return nil
+	}
+	audit, parts, err := auditOps(changes)
+	if err != nil {
+		return err
+	}
+	ops := append([]clientv3.Op{clientv3.OpDelete(d.Key, opts...)}, parts...)
+	commitOpts.Rev, commitOpts.Always = rev, audit
+	if rev, err = cli.CommitCtx(ctx, ops, commitOpts); err != nil {
+		return err
 	}
-	return cli.Delete(d.Key)
+	logrus.Infof("del %d key, all success at revision %d", len(changes), rev)
+	return nil
 }
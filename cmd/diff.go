@@ -0,0 +1,152 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <left> <right>",
+	Short: "compare two etcd paths or a config file with etcd",
+	Long: `Compare the keys of two sides, each being either an etcd dsn
+(host:port/path) or a json/yaml config file. Keys are compared relative to
+the dsn path or the file root. Exits with 2 when the sides differ.
+
+Example:
+  etcd-tool diff staging:2379/my_project prod:2379/my_project
+  etcd-tool diff conf.json localhost:2379/my_project`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := diffArg.Run(args); err != nil {
+			if err == errPlanNotEmpty {
+				os.Exit(2)
+			}
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var diffArg DiffArg
+
+type DiffArg struct {
+	Json     bool
+	WithDirs bool
+}
+
+type DiffReport struct {
+	Left    string   `json:"left"`
+	Right   string   `json:"right"`
+	Changes []Change `json:"changes"`
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().BoolVar(&diffArg.Json, "json", false, "print the report as json")
+	diffCmd.Flags().BoolVar(&diffArg.WithDirs, "with-dirs", false, "also compare dir placeholder keys")
+}
+
+func (d *DiffArg) Run(args []string) error {
+	if len(args) != 2 {
+		return errors.New("invalid params")
+	}
+	left, err := d.load(args[0])
+	if err != nil {
+		return err
+	}
+	right, err := d.load(args[1])
+	if err != nil {
+		return err
+	}
+
+	report := DiffReport{
+		Left:    args[0],
+		Right:   args[1],
+		Changes: diffKvs(left, right),
+	}
+	if d.Json {
+		buf, err := marshalIndent(report)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+	} else {
+		plan := Plan{Changes: report.Changes}
+		plan.Print(os.Stdout, isTerminal(os.Stdout))
+		fmt.Printf("%d added, %d removed, %d changed\n",
+			plan.Count(changeAdd), plan.Count(changeDelete), plan.Count(changeUpdate))
+	}
+	if len(report.Changes) > 0 {
+		return errPlanNotEmpty
+	}
+	return nil
+}
+
+// load returns the kvs of one side relative to its base path, a side is a
+// config file if it exists on disk and a dsn otherwise
+func (d *DiffArg) load(side string) (map[string]string, error) {
+	if _, err := os.Stat(side); err == nil {
+		confMap, err := loadConf(side, "")
+		if err != nil {
+			return nil, err
+		}
+		return flattenConf(confMap)
+	}
+
+	cfg := client.ParseDSN(side)
+	if cfg == nil {
+		return nil, errors.Errorf("%s is neither a file nor a dsn", side)
+	}
+	cli, err := client.NewClient(side)
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	kvs, err := cli.GetWithPrefix(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !d.WithDirs {
+		dropDirKeys(kvs)
+	}
+	ret := make(map[string]string, len(kvs))
+	for k, v := range kvs {
+		ret[strings.TrimPrefix(k, cfg.Path)] = v
+	}
+	return ret, nil
+}
+
+// dropDirKeys removes the dir placeholders written by --dir_value, i.e. keys
+// which also have children
+func dropDirKeys(kvs map[string]string) {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		for i := strings.LastIndex(k, delimiter); i > 0; i = strings.LastIndex(k[:i], delimiter) {
+			delete(kvs, k[:i])
+		}
+	}
+}
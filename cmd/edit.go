@@ -0,0 +1,374 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// editCmd represents the edit command
+var editCmd = &cobra.Command{
+	Use:   "edit [path]",
+	Short: "edit a dir in $EDITOR and apply the changes",
+	Long: `Export the dir at the dsn path, optionally narrowed by [path], to a temp
+file, open it in $EDITOR and apply the changes once saved. Removed keys are
+deleted. The changes are applied only if nothing under the dir was modified
+since it was read, otherwise the concurrent changes are printed and the editor
+can be re-opened with your changes replayed on top of them. A key changed on
+both sides holds both values behind <<<<<<< until you pick one.
+
+Example:
+  EDITOR=vim etcd-tool edit -e localhost:2379/my_project /redis --format yaml`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := editArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var editArg EditArg
+
+type EditArg struct {
+	Dsn       string
+	Format    string
+	Yes       bool
+	MaxTxnOps int
+}
+
+// errEditCancelled is returned when the user gives up an edit
+var errEditCancelled = errors.New("edit cancelled")
+
+func init() {
+	RootCmd.AddCommand(editCmd)
+
+	editCmd.Flags().StringVarP(&editArg.Dsn, "etcd", "e", "", "etcd address")
+	editCmd.Flags().StringVar(&editArg.Format, "format", formatJSON, "format to edit in, json or yaml")
+	editCmd.Flags().BoolVarP(&editArg.Yes, "yes", "y", false, "apply without asking")
+	editCmd.Flags().IntVar(&editArg.MaxTxnOps, "max-txn-ops", client.DefaultMaxTxnOps, "max operations per transaction")
+}
+
+func (e *EditArg) Run(args []string) error {
+	cfg := client.ParseDSN(e.Dsn)
+	if cfg == nil || len(args) > 1 {
+		return errors.New("invalid params")
+	}
+	if e.Format != formatJSON && e.Format != formatYAML {
+		return errors.Errorf("unknown format %s, expect json or yaml", e.Format)
+	}
+	key := strings.TrimSuffix(cfg.Path, delimiter)
+	if len(args) == 1 {
+		key = joinKey(cfg.Path, args[0])
+	}
+	cli, err := client.NewClient(e.Dsn)
+	if err != nil {
+		return err
+	}
+
+	before, rev, err := cli.GetWithPrefixRev(key + delimiter)
+	if err != nil {
+		return err
+	}
+	original, err := e.encode(key, before)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "etcd-tool-*."+e.Format)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := ioutil.WriteFile(f.Name(), original, 0600); err != nil {
+		return err
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	for {
+		if err := runEditor(f.Name()); err != nil {
+			return err
+		}
+		edited, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(edited, original) {
+			fmt.Println("no changes")
+			return nil
+		}
+		confMap, err := parseConf(edited, e.Format)
+		var after map[string]string
+		if err == nil {
+			after, err = editAfter(key, before, confMap)
+		}
+		if err != nil {
+			fmt.Printf("invalid %s: %s\n", e.Format, err.Error())
+			if !confirm(stdin, "re-open the editor? [Y/n] ", true) {
+				return errEditCancelled
+			}
+			continue
+		}
+
+		plan := &Plan{Changes: diffKvs(before, after), Rev: rev}
+		if plan.Empty() {
+			fmt.Println("no changes")
+			return nil
+		}
+		plan.Print(os.Stdout, isTerminal(os.Stdout))
+		fmt.Printf("plan: %s\n", plan.Summary())
+		if !e.Yes {
+			switch line, _ := answer(stdin, "apply? [y/N/e(dit again)] "); line {
+			case "y", "yes":
+			case "e", "edit":
+				continue
+			default:
+				return errEditCancelled
+			}
+		}
+
+		audit, parts, err := auditOps(plan.Changes)
+		if err != nil {
+			return err
+		}
+		applied, err := cli.Commit(append(plan.Ops(), parts...), e.MaxTxnOps, key+delimiter, rev, audit...)
+		if err == nil {
+			logrus.Infof("edit %s, %s, all success at revision %d", key, plan.Summary(), applied)
+			return nil
+		}
+		if err != client.ErrTxnConflict {
+			return err
+		}
+
+		// rebase the edit on the current values: the user's changes are
+		// replayed on them and the keys changed on both sides are marked
+		now, nowRev, err := cli.GetWithPrefixRev(key + delimiter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s was modified since revision %d:\n", key+delimiter, rev)
+		concurrent := &Plan{Changes: diffKvs(before, now)}
+		concurrent.Print(os.Stdout, isTerminal(os.Stdout))
+		merged, conflicts := rebaseEdit(before, after, now)
+		if len(conflicts) > 0 {
+			fmt.Printf("changed on both sides, edit the values marked %q: %s\n", conflictMarker, strings.Join(conflicts, ", "))
+		}
+		if !confirm(stdin, "re-open the editor with your changes on top of them? [Y/n] ", true) {
+			return client.ErrTxnConflict
+		}
+		if original, err = e.encode(key, now); err != nil {
+			return err
+		}
+		buf, err := e.encode(key, merged)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(f.Name(), buf, 0600); err != nil {
+			return err
+		}
+		before, rev = now, nowRev
+	}
+}
+
+// conflictMarker starts the value rebaseEdit gives a key changed on both
+// sides, the edit can't be applied until it is replaced
+const conflictMarker = "<<<<<<< "
+
+// rebaseEdit replays the edit of before into after onto now, the values
+// changed concurrently are kept. The keys changed on both sides to different
+// values get a conflictMarker value holding both, they are returned sorted.
+func rebaseEdit(before, after, now map[string]string) (map[string]string, []string) {
+	merged := make(map[string]string, len(now))
+	for k, v := range now {
+		merged[k] = v
+	}
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	var conflicts []string
+	for k := range keys {
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		n, inNow := now[k]
+		if inBefore == inAfter && b == a {
+			continue
+		}
+		if inBefore == inNow && b == n {
+			if inAfter {
+				merged[k] = a
+			} else {
+				delete(merged, k)
+			}
+			continue
+		}
+		if inAfter == inNow && a == n {
+			continue
+		}
+		yours, theirs := "(deleted)", "(deleted)"
+		if inAfter {
+			yours = a
+		}
+		if inNow {
+			theirs = n
+		}
+		merged[k] = fmt.Sprintf("%syours: %s ======= theirs: %s >>>>>>>", conflictMarker, yours, theirs)
+		conflicts = append(conflicts, k)
+	}
+	sort.Strings(conflicts)
+	return merged, conflicts
+}
+
+func (e *EditArg) encode(key string, kvs map[string]string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := encoders[e.Format](buf, key, buildTree(key, kvs), ExportOptions{}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// editAfter returns the keys under key once edited into confMap. The keys
+// buildTree leaves out of the document, dir placeholders and keys with empty
+// segments, are kept as they are, and so are the values the document decodes
+// to unchanged, e.g. 1.50 which reads back as 1.5. Empty values are kept, a
+// conflict left by rebaseEdit is an error.
+func editAfter(key string, before map[string]string, confMap map[string]interface{}) (map[string]string, error) {
+	after := map[string]string{}
+	if err := flattenEdited(confMap, key+delimiter, after); err != nil {
+		return nil, err
+	}
+	for k, v := range after {
+		if strings.HasPrefix(v, conflictMarker) {
+			return nil, errors.Errorf("unresolved conflict at %s", k)
+		}
+		if old, ok := before[k]; ok && sameValue(old, v) {
+			after[k] = old
+		}
+	}
+	base := key + delimiter
+	for k, v := range before {
+		if _, ok := after[k]; ok {
+			continue
+		}
+		rel := strings.TrimPrefix(k, base)
+		if rel == "" || strings.Contains(rel, "//") || strings.HasSuffix(rel, delimiter) {
+			after[k] = v
+			continue
+		}
+		for other := range before {
+			if strings.HasPrefix(other, k+delimiter) {
+				after[k] = v
+				break
+			}
+		}
+	}
+	return after, nil
+}
+
+// flattenEdited is flattenConf keeping the empty values, which put takes as
+// deletes, since an edited document holds every key
+func flattenEdited(confMap map[string]interface{}, baseKey string, kvs map[string]string) error {
+	for k, value := range confMap {
+		if strings.Contains(k, delimiter) {
+			return errors.Errorf("invalid key %s, contains %s", k, delimiter)
+		}
+		switch val := value.(type) {
+		case map[string]interface{}:
+			if err := flattenEdited(val, baseKey+k+delimiter, kvs); err != nil {
+				return err
+			}
+		case string:
+			kvs[baseKey+k] = val
+		default:
+			buf, err := jsoniter.Marshal(val)
+			if err != nil {
+				return err
+			}
+			kvs[baseKey+k] = string(buf)
+		}
+	}
+	return nil
+}
+
+// sameValue reports whether two values are equal once decoded as json, or as
+// strings when they aren't json
+func sameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal([]byte(a), &va) != nil || json.Unmarshal([]byte(b), &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+// runEditor opens path in $EDITOR, vi by default, which may hold arguments
+func runEditor(path string) error {
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Errorf("%s: %s", editor, err.Error())
+	}
+	return nil
+}
+
+// answer prints prompt and returns the lower cased line typed, ok is false
+// when the input ended before anything was typed
+func answer(r *bufio.Reader, prompt string) (line string, ok bool) {
+	fmt.Print(prompt)
+	line, err := r.ReadString('\n')
+	line = strings.ToLower(strings.TrimSpace(line))
+	return line, err == nil || line != ""
+}
+
+// confirm asks a yes or no question, an empty line is def and the end of the
+// input is no, so nothing is agreed to without someone to ask
+func confirm(r *bufio.Reader, prompt string, def bool) bool {
+	line, ok := answer(r, prompt)
+	if !ok {
+		fmt.Println()
+		return false
+	}
+	switch line {
+	case "":
+		return def
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditAfter(t *testing.T) {
+	before := map[string]string{
+		"/app/redis":         "dir",
+		"/app/redis/address": "localhost:6379",
+		"/app/redis/db":      "1",
+		"/app/odd//key":      "x",
+		"/app/name":          "app",
+	}
+	confMap, err := parseConf([]byte(`{"redis": {"address": "redis:6379", "pool": 10}, "name": {"first": "app"}}`), formatJSON)
+	assert.Nil(t, err)
+	after, err := editAfter("/app", before, confMap)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"/app/redis":         "dir",
+		"/app/redis/address": "redis:6379",
+		"/app/redis/pool":    "10",
+		"/app/odd//key":      "x",
+		"/app/name/first":    "app",
+	}, after)
+}
+
+func TestEditAfter_Unchanged(t *testing.T) {
+	before := map[string]string{
+		"/app/empty":         "",
+		"/app/ratio":         "1.50",
+		"/app/ids":           "[1, 2]",
+		"/app/none":          "[]",
+		"/app/on":            "true",
+		"/app/redis":         "dir",
+		"/app/redis/address": "localhost:6379",
+		"/app/odd//key":      "x",
+	}
+	for _, format := range []string{formatJSON, formatYAML} {
+		e := EditArg{Format: format}
+		buf, err := e.encode("/app", before)
+		assert.Nil(t, err)
+		confMap, err := parseConf(buf, format)
+		assert.Nil(t, err)
+		after, err := editAfter("/app", before, confMap)
+		assert.Nil(t, err)
+		assert.Equal(t, before, after, format)
+		plan := &Plan{Changes: diffKvs(before, after)}
+		assert.True(t, plan.Empty(), format)
+	}
+}
+
+func TestRebaseEdit(t *testing.T) {
+	before := map[string]string{"/app/a": "1", "/app/b": "1", "/app/c": "1", "/app/d": "1"}
+	// the user changes a and c and deletes d, meanwhile b and c change
+	after := map[string]string{"/app/a": "2", "/app/b": "1", "/app/c": "2"}
+	now := map[string]string{"/app/a": "1", "/app/b": "3", "/app/c": "3", "/app/d": "1", "/app/e": "1"}
+	merged, conflicts := rebaseEdit(before, after, now)
+	assert.Equal(t, []string{"/app/c"}, conflicts)
+	assert.Equal(t, "2", merged["/app/a"])
+	assert.Equal(t, "3", merged["/app/b"])
+	assert.Equal(t, "1", merged["/app/e"])
+	assert.NotContains(t, merged, "/app/d")
+	assert.Equal(t, "<<<<<<< yours: 2 ======= theirs: 3 >>>>>>>", merged["/app/c"])
+
+	// saved as is the conflict blocks the edit
+	confMap, err := parseConf([]byte(`{"c": "<<<<<<< yours: 2 ======= theirs: 3 >>>>>>>"}`), formatJSON)
+	assert.Nil(t, err)
+	_, err = editAfter("/app", now, confMap)
+	assert.EqualError(t, err, "unresolved conflict at /app/c")
+}
+
+func TestConfirm(t *testing.T) {
+	read := func(s string) *bufio.Reader { return bufio.NewReader(strings.NewReader(s)) }
+	assert.True(t, confirm(read("\n"), "", true))
+	assert.False(t, confirm(read(""), "", true))
+	assert.False(t, confirm(read("n\n"), "", true))
+	assert.True(t, confirm(read("y"), "", false))
+}
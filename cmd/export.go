@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-errors/errors"
+	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v2"
+)
+
+const formatFlat = "flat"
+
+// ExportOptions tunes the flat encoders
+type ExportOptions struct {
+	// EnvCase is upper, lower or keep, applied to dotenv keys
+	EnvCase string
+	// EnvPrefix is prepended to dotenv keys
+	EnvPrefix string
+}
+
+// encoder writes tree, the document exported from key
+type encoder func(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error
+
+// encoders maps the --output formats to their encoder, add new formats here
+var encoders = map[string]encoder{
+	formatJSON:       encodeJSON,
+	formatYAML:       encodeYAML,
+	formatTOML:       encodeTOML,
+	formatDotenv:     encodeDotenv,
+	formatProperties: encodeProperties,
+	formatFlat:       encodeFlat,
+}
+
+func exportFormats() []string {
+	var names []string
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func encodeJSON(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	buf, err := marshalIndent(tree)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func encodeYAML(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	buf, err := yaml.Marshal(nativeNumbers(tree))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf)
+	return err
+}
+
+func encodeTOML(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	native := nativeNumbers(tree).(map[string]interface{})
+	for _, leaf := range flattenTree(native) {
+		if err := checkTOML(leaf.path, leaf.value); err != nil {
+			return err
+		}
+	}
+	t, err := toml.TreeFromMap(native)
+	if err != nil {
+		return err
+	}
+	_, err = t.WriteTo(w)
+	return err
+}
+
+func encodeDotenv(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	for _, leaf := range flattenTree(tree) {
+		k := opts.EnvPrefix + strings.Replace(leaf.path, delimiter, "_", -1)
+		switch opts.EnvCase {
+		case "lower":
+			k = strings.ToLower(k)
+		case "keep":
+		default:
+			k = strings.ToUpper(k)
+		}
+		val := leafString(leaf.value)
+		if strings.ContainsAny(val, " \t\n\"'#$\\`") {
+			val = strconv.Quote(val)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkTOML rejects the values toml can't represent: null and arrays mixing
+// types, which go-toml would panic on
+func checkTOML(path string, v interface{}) error {
+	switch val := v.(type) {
+	case nil:
+		return errors.Errorf("%s is null, which toml can't represent", path)
+	case []interface{}:
+		for i, item := range val {
+			if i > 0 && reflect.TypeOf(item) != reflect.TypeOf(val[0]) {
+				return errors.Errorf("%s mixes types, which toml can't represent", path)
+			}
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			if m, ok := item.(map[string]interface{}); ok {
+				for _, leaf := range flattenTree(m) {
+					if err := checkTOML(itemPath+delimiter+leaf.path, leaf.value); err != nil {
+						return err
+					}
+				}
+			} else if err := checkTOML(itemPath, item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var propertiesEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+var propertiesKeyEscaper = strings.NewReplacer(`\`, `\\`, " ", `\ `, "=", `\=`, ":", `\:`, "#", `\#`, "!", `\!`)
+
+func encodeProperties(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	for _, leaf := range flattenTree(tree) {
+		k := propertiesKeyEscaper.Replace(strings.Replace(leaf.path, delimiter, ".", -1))
+		val := propertiesEscaper.Replace(leafString(leaf.value))
+		if strings.HasPrefix(val, " ") {
+			val = `\` + val
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", k, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeFlat writes one full etcd key and its value per line, tab separated
+func encodeFlat(w io.Writer, key string, tree map[string]interface{}, opts ExportOptions) error {
+	for _, leaf := range flattenTree(tree) {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", joinKey(key, leaf.path), leafString(leaf.value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type treeLeaf struct {
+	path  string
+	value interface{}
+}
+
+// flattenTree returns the leaves of tree sorted by path, paths are relative
+// and joined by delimiter
+func flattenTree(tree map[string]interface{}) []treeLeaf {
+	var leaves []treeLeaf
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if sub, ok := v.(map[string]interface{}); ok {
+				walk(prefix+k+delimiter, sub)
+			} else {
+				leaves = append(leaves, treeLeaf{path: prefix + k, value: v})
+			}
+		}
+	}
+	walk("", tree)
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].path < leaves[j].path
+	})
+	return leaves
+}
+
+// leafString returns a leaf as it is stored in etcd
+func leafString(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	default:
+		buf, _ := marshalCompact(val)
+		return string(buf)
+	}
+}
+
+// marshalCompact is json.Marshal without html escaping
+func marshalCompact(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// nativeNumbers replaces the json.Number of a tree with int64 or float64, so
+// yaml and toml don't print them as strings
+func nativeNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			ret[k] = nativeNumbers(item)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(val))
+		for i, item := range val {
+			ret[i] = nativeNumbers(item)
+		}
+		return ret
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	default:
+		return val
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncoders(t *testing.T) {
+	kvs := map[string]string{
+		"/test/redis/address": "localhost:6379",
+		"/test/redis/db":      "3",
+		"/test/redis/ratio":   "0.5",
+		"/test/redis/enabled": "true",
+		"/test/redis/hosts":   `["a","b"]`,
+		"/test/redis/prefix":  "a b=c#d",
+		"/test/name":          "test",
+	}
+	tree := buildTree("/test", kvs)
+	for _, format := range []string{formatJSON, formatYAML, formatTOML, formatProperties} {
+		buf := &bytes.Buffer{}
+		assert.Nil(t, encoders[format](buf, "/test", tree, ExportOptions{}), format)
+		confMap, err := parseConf(buf.Bytes(), format)
+		assert.Nil(t, err, format)
+		if format == formatProperties {
+			// properties values are always strings
+			confMap["redis"].(map[string]interface{})["hosts"] = []interface{}{"a", "b"}
+		}
+		got, err := flattenConf(confMap)
+		assert.Nil(t, err, format)
+		for k, v := range got {
+			assert.Equal(t, kvs["/test/"+k], v, format+" "+k)
+		}
+		assert.Equal(t, len(kvs), len(got), format)
+	}
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, encodeDotenv(buf, "/test", tree, ExportOptions{EnvPrefix: "app_"}))
+	confMap, err := parseConf(buf.Bytes(), formatDotenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "a b=c#d", confMap["APP_REDIS_PREFIX"])
+	assert.Equal(t, `["a","b"]`, confMap["APP_REDIS_HOSTS"])
+
+	buf.Reset()
+	assert.Nil(t, encodeFlat(buf, "/test", tree, ExportOptions{}))
+	assert.Contains(t, buf.String(), "/test/redis/db\t3\n")
+
+	mixed := buildTree("/test", map[string]string{"/test/a": "[1,1.5]"})
+	assert.NotNil(t, encodeTOML(buf, "/test", mixed, ExportOptions{}))
+}
@@ -0,0 +1,210 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/json-iterator/go"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// getCmd represents the get command
+var getCmd = &cobra.Command{
+	Use:     "get [path]",
+	Aliases: []string{"export"},
+	Short:   "export etcd data as json, yaml, toml, dotenv, properties or flat",
+	Long: `Read every key under the dsn path (optionally narrowed by [path]) and
+print it as a nested document, in the same layout accepted by "put -c".
+
+Example:
+  etcd-tool get -e localhost:2379/my_group /redis
+  etcd-tool export -e localhost:2379/my_group /redis -o dotenv --env-prefix APP_`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := getArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var getArg GetArg
+
+type GetArg struct {
+	Dsn    string
+	Key    string
+	Cfg    *client.Config
+	Output string
+	ExportOptions
+}
+
+var (
+	// jsonNumber keeps numbers as json.Number so big integers and floats are
+	// printed back exactly as they were stored
+	jsonNumber = jsoniter.Config{UseNumber: true}.Froze()
+)
+
+func init() {
+	RootCmd.AddCommand(getCmd)
+
+	getCmd.Flags().StringVarP(&getArg.Dsn, "etcd", "e", "", "etcd address")
+	getCmd.Flags().StringVarP(&getArg.Output, "output", "o", formatJSON, "output format: "+strings.Join(exportFormats(), ", "))
+	getCmd.Flags().StringVar(&getArg.EnvCase, "env-case", "upper", "case of dotenv keys: upper, lower or keep")
+	getCmd.Flags().StringVar(&getArg.EnvPrefix, "env-prefix", "", "prefix of dotenv keys")
+}
+
+func (g *GetArg) Run(args []string) error {
+	if len(args) > 1 {
+		return errors.New("invalid params")
+	}
+	g.Cfg = client.ParseDSN(g.Dsn)
+	if g.Cfg == nil {
+		return errors.New("invalid params")
+	}
+	enc, ok := encoders[g.Output]
+	if !ok {
+		return errors.Errorf("unknown output %s, expect one of %s", g.Output, strings.Join(exportFormats(), ", "))
+	}
+	g.Key = strings.TrimSuffix(g.Cfg.Path, delimiter)
+	if len(args) == 1 {
+		g.Key = joinKey(g.Cfg.Path, args[0])
+	}
+
+	cli, err := client.NewClient(g.Dsn)
+	if err != nil {
+		return err
+	}
+	result, err := exportKey(cli, g.Key)
+	if err != nil {
+		return err
+	}
+	if _, ok := result.(map[string]interface{}); !ok && g.Output == formatJSON {
+		buf, err := marshalIndent(result)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	// a single value is exported as a document holding its last segment
+	base, tree := g.Key, map[string]interface{}{}
+	if m, ok := result.(map[string]interface{}); ok {
+		tree = m
+	} else {
+		idx := strings.LastIndex(g.Key, delimiter)
+		base, tree[g.Key[idx+1:]] = g.Key[:idx], result
+	}
+	return enc(os.Stdout, base, tree, g.ExportOptions)
+}
+
+// exportKey reads the subtree under key, falling back to the value of key
+// itself when it has no children
+func exportKey(cli *client.Client, key string) (interface{}, error) {
+	kvs, err := cli.GetWithPrefix(key + delimiter)
+	if err != nil {
+		return nil, err
+	}
+	if len(kvs) > 0 {
+		return buildTree(key, kvs), nil
+	}
+	val, err := cli.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if val == "" {
+		return nil, errors.Errorf("key %s not found", key)
+	}
+	return decodeValue(val), nil
+}
+
+// buildTree rebuilds the nested document that parseKeyValue flattened.
+// When a key holds both a value and children (dir placeholders written by
+// --dir_value) the children win, same as config.parseKvs.
+func buildTree(baseKey string, kvs map[string]string) map[string]interface{} {
+	if !strings.HasSuffix(baseKey, delimiter) {
+		baseKey = baseKey + delimiter
+	}
+	result := map[string]interface{}{}
+	mapSubKvs := map[string]map[string]string{}
+	for key, val := range kvs {
+		if !strings.HasPrefix(key, baseKey) {
+			continue
+		}
+		k := strings.TrimPrefix(key, baseKey)
+		if k == "" || strings.Contains(k, "//") || strings.HasSuffix(k, delimiter) {
+			continue
+		}
+		if splitKey := strings.Split(k, delimiter); len(splitKey) > 1 {
+			if mapSubKvs[splitKey[0]] == nil {
+				mapSubKvs[splitKey[0]] = map[string]string{}
+			}
+			mapSubKvs[splitKey[0]][key] = val
+		} else {
+			result[k] = decodeValue(val)
+		}
+	}
+	for key, val := range mapSubKvs {
+		result[key] = buildTree(fmt.Sprintf("%s%s", baseKey, key), val)
+	}
+	return result
+}
+
+// decodeValue turns a value written by parseKeyValue back into its json type.
+// Strings were stored raw and objects are always flattened into dirs, so only
+// numbers, bools, arrays and null are decoded; everything else stays a string.
+func decodeValue(val string) interface{} {
+	var v interface{}
+	if err := jsonNumber.UnmarshalFromString(val, &v); err != nil {
+		return val
+	}
+	switch v.(type) {
+	case nil, bool, json.Number, []interface{}:
+		return v
+	default:
+		return val
+	}
+}
+
+// marshalIndent prints v with sorted keys and without html escaping, so the
+// output is stable and can be committed next to the original config file
+func marshalIndent(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// joinKey appends a user supplied key to the dsn path
+func joinKey(path, key string) string {
+	key = strings.Trim(key, delimiter)
+	if key == "" {
+		return strings.TrimSuffix(path, delimiter)
+	}
+	if !strings.HasSuffix(path, delimiter) {
+		path += delimiter
+	}
+	return path + key
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTree(t *testing.T) {
+	conf := `{
+		"redis": {
+			"address": "localhost:6379",
+			"db": 3,
+			"timeout": 1.5,
+			"enabled": true,
+			"hosts": ["a", "b"],
+			"quoted": "\"zxc\"",
+			"raw": "{\"a\":1}",
+			"pool": {"size": 10}
+		}
+	}`
+	p := PutArg{Kvs: map[string]string{}}
+	confMap := map[string]interface{}{}
+	assert.Nil(t, jsoniter.Unmarshal([]byte(conf), &confMap))
+	assert.Nil(t, p.parseKeyValue(confMap, "/test/"))
+
+	kvs := map[string]string{"/test/redis": "dir"}
+	for k, v := range p.Kvs {
+		kvs[k] = v
+	}
+	tree := buildTree("/test", kvs)
+	bytes, err := marshalIndent(tree)
+	assert.Nil(t, err)
+
+	p2 := PutArg{Kvs: map[string]string{}}
+	confMap2 := map[string]interface{}{}
+	assert.Nil(t, jsoniter.Unmarshal(bytes, &confMap2))
+	assert.Nil(t, p2.parseKeyValue(confMap2, "/test/"))
+	assert.Equal(t, p.Kvs, p2.Kvs)
+}
+
+func TestDecodeValue(t *testing.T) {
+	assert.Equal(t, "abc", decodeValue("abc"))
+	assert.Equal(t, "123abc", decodeValue("123abc"))
+	assert.Equal(t, `"abc"`, decodeValue(`"abc"`))
+	assert.Equal(t, `{"a":1}`, decodeValue(`{"a":1}`))
+	assert.Equal(t, true, decodeValue("true"))
+	assert.Nil(t, decodeValue("null"))
+	assert.Equal(t, "12345678901234567890", string(decodeValue("12345678901234567890").(json.Number)))
+}
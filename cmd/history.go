@@ -0,0 +1,147 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history <path>",
+	Short: "list the past values of a key",
+	Long: `List every value a key had since its first creation, or since the
+compaction point, newest first. Deletions are listed too, so the values a
+key had before it was deleted and put again are kept. With --prefix every
+key under the path is listed, the deleted ones included.
+
+Example:
+  etcd-tool history -e localhost:2379/my_project /redis/address`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := historyArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var historyArg HistoryArg
+
+type HistoryArg struct {
+	Dsn    string
+	Prefix bool
+	Json   bool
+}
+
+type HistoryEntry struct {
+	Key      string `json:"key"`
+	Revision int64  `json:"revision"`
+	Version  int64  `json:"version"`
+	Value    string `json:"value"`
+	Deleted  bool   `json:"deleted,omitempty"`
+	Author   string `json:"author,omitempty"`
+}
+
+func init() {
+	RootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().StringVarP(&historyArg.Dsn, "etcd", "e", "", "etcd address")
+	historyCmd.Flags().BoolVarP(&historyArg.Prefix, "prefix", "p", false, "list every key under the path")
+	historyCmd.Flags().BoolVar(&historyArg.Json, "json", false, "print as json")
+}
+
+func (h *HistoryArg) Run(args []string) error {
+	cfg := client.ParseDSN(h.Dsn)
+	if cfg == nil || len(args) != 1 {
+		return errors.New("invalid params")
+	}
+	key := joinKey(cfg.Path, args[0])
+
+	cli, err := client.NewClient(h.Dsn)
+	if err != nil {
+		return err
+	}
+	var kvs []client.KV
+	if h.Prefix {
+		kvs, err = cli.HistoryWithPrefix(key + delimiter)
+	} else {
+		kvs, err = cli.History(key)
+	}
+	if err != nil {
+		return err
+	}
+
+	var entries []HistoryEntry
+	var minRev int64
+	for _, kv := range kvs {
+		if minRev == 0 || kv.ModRevision < minRev {
+			minRev = kv.ModRevision
+		}
+		entries = append(entries, HistoryEntry{
+			Key:      kv.Key,
+			Revision: kv.ModRevision,
+			Version:  kv.Version,
+			Value:    kv.Value,
+			Deleted:  kv.Version == 0,
+		})
+	}
+
+	// only the entries which ended after the oldest revision listed can have
+	// written it
+	var audit []AuditEntry
+	if len(entries) > 0 {
+		err = loadAudit(cli, minRev, func(e *AuditEntry) (bool, error) {
+			audit = append(audit, *e)
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i := range entries {
+		if entries[i].Author, err = auditAuthor(cli, audit, entries[i].Key, entries[i].Revision); err != nil {
+			return err
+		}
+	}
+	if len(entries) == 0 {
+		return errors.Errorf("key %s not found", key)
+	}
+
+	if h.Json {
+		buf, err := marshalIndent(entries)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tREVISION\tVERSION\tAUTHOR\tVALUE")
+	for _, e := range entries {
+		val := e.Value
+		if e.Deleted {
+			val = "(deleted)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", e.Key, e.Revision, e.Version, e.Author, val)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// LockOptions configures the lock taken by the commands writing to etcd
+type LockOptions struct {
+	// Key overrides the lock key derived from the target path
+	Key     string
+	Timeout time.Duration
+}
+
+func addLockFlags(cmd *cobra.Command, opts *LockOptions) {
+	cmd.Flags().StringVar(&opts.Key, "lock-key", "", "lock key, by default the path is locked against writes to the paths over and under it")
+	cmd.Flags().DurationVar(&opts.Timeout, "lock-timeout", 30*time.Second, "how long to wait for the lock, 0 to wait forever")
+}
+
+// lockPrefix holds the locks of the paths written to
+const lockPrefix = reservedPrefix + "lock"
+
+// lockPath normalises path for LockPath: "/app/redis/" is "/app/redis" and
+// the root is ""
+func lockPath(path string) string {
+	path = strings.Trim(path, delimiter)
+	if path == "" {
+		return ""
+	}
+	return delimiter + path
+}
+
+// lock acquires the lock of path, the returned func releases it. ctx is the
+// command one from signalContext, an interrupted command returns through its
+// deferred release.
+func (o LockOptions) lock(ctx context.Context, cli *client.Client, path string) (func(), error) {
+	key := o.Key
+	var l *client.Lock
+	var err error
+	if key == "" {
+		key = lockPrefix + lockPath(path)
+		l, err = cli.LockPath(ctx, lockPrefix, lockPath(path), lockHolder(), o.Timeout)
+	} else {
+		l, err = cli.LockCtx(ctx, key, lockHolder(), o.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("locked %s", key)
+	return func() {
+		if err := l.Unlock(); err != nil {
+			logrus.Errorf("release lock %s: %s", key, err.Error())
+		}
+	}, nil
+}
+
+// lockHolder describes this process for the waiters of its lock
+func lockHolder() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s pid %d since %s", currentUser(), host, os.Getpid(), time.Now().Format(time.RFC3339))
+}
+
+// currentUser is $USER, or the name of the process owner when it is unset
+func currentUser() string {
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockPath(t *testing.T) {
+	assert.Equal(t, "/app", lockPath("/app/"))
+	assert.Equal(t, "/app/redis/address", lockPath("/app/redis/address"))
+	assert.Equal(t, "", lockPath("/"))
+}
@@ -0,0 +1,95 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls [path]",
+	Short: "list the dirs and keys directly under a path",
+	Long: `List the children of the dsn path, optionally narrowed by [path], dirs end
+with /. Only keys are read.
+
+Example:
+  etcd-tool ls -e localhost:2379/my_project /redis`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := lsArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var lsArg LsArg
+
+type LsArg struct {
+	Dsn  string
+	Long bool
+}
+
+func init() {
+	RootCmd.AddCommand(lsCmd)
+
+	lsCmd.Flags().StringVarP(&lsArg.Dsn, "etcd", "e", "", "etcd address")
+	lsCmd.Flags().BoolVarP(&lsArg.Long, "long", "l", false, "also print the number of keys of every dir")
+}
+
+func (l *LsArg) Run(args []string) error {
+	cfg := client.ParseDSN(l.Dsn)
+	if cfg == nil || len(args) > 1 {
+		return errors.New("invalid params")
+	}
+	key := strings.TrimSuffix(cfg.Path, delimiter)
+	if len(args) == 1 {
+		key = joinKey(cfg.Path, args[0])
+	}
+	cli, err := client.NewClient(l.Dsn)
+	if err != nil {
+		return err
+	}
+	root, err := loadKeyTree(cli, key, false)
+	if err != nil {
+		return err
+	}
+	if !root.isDir() {
+		return errors.Errorf("dir %s not found", key+delimiter)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, name := range root.names() {
+		if !l.Long {
+			fmt.Fprintln(w, name)
+			continue
+		}
+		child := root.children[strings.TrimSuffix(name, delimiter)]
+		keys := ""
+		if child.isDir() {
+			keys = fmt.Sprint(child.keys)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", keys, name)
+	}
+	return w.Flush()
+}
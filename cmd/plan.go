@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+)
+
+const (
+	changeAdd    = "add"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorReset  = "\033[0m"
+)
+
+// Change is a single key modification
+type Change struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+	Old  string `json:"old,omitempty"`
+	New  string `json:"new,omitempty"`
+	// Dir marks dir placeholder keys, see --dir_value
+	Dir bool `json:"dir,omitempty"`
+}
+
+// Plan lists every key a write command is going to touch, sorted by key
+type Plan struct {
+	Changes []Change `json:"changes"`
+	DelDirs []string `json:"delete_dirs,omitempty"`
+	// Rev is the revision the plan was computed at
+	Rev int64 `json:"revision"`
+}
+
+// diffKvs returns the changes turning before into after
+func diffKvs(before, after map[string]string) []Change {
+	changes := []Change{}
+	for k, v := range after {
+		old, ok := before[k]
+		if !ok {
+			changes = append(changes, Change{Type: changeAdd, Key: k, New: v})
+		} else if old != v {
+			changes = append(changes, Change{Type: changeUpdate, Key: k, Old: old, New: v})
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, Change{Type: changeDelete, Key: k, Old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// deleteTree removes key and everything below it
+func deleteTree(kvs map[string]string, key string) {
+	delete(kvs, key)
+	prefix := strings.TrimSuffix(key, delimiter) + delimiter
+	for k := range kvs {
+		if strings.HasPrefix(k, prefix) {
+			delete(kvs, k)
+		}
+	}
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Count returns the number of changes of type t
+func (p *Plan) Count(t string) int {
+	n := 0
+	for _, c := range p.Changes {
+		if c.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *Plan) Summary() string {
+	return fmt.Sprintf("%d to add, %d to update, %d to delete",
+		p.Count(changeAdd), p.Count(changeUpdate), p.Count(changeDelete))
+}
+
+// Ops converts the changes to etcd operations
+func (p *Plan) Ops() []clientv3.Op {
+	ops := make([]clientv3.Op, 0, len(p.Changes))
+	for _, c := range p.Changes {
+		if c.Type == changeDelete {
+			ops = append(ops, clientv3.OpDelete(c.Key))
+		} else {
+			ops = append(ops, clientv3.OpPut(c.Key, c.New))
+		}
+	}
+	return ops
+}
+
+// Print writes the plan as a diff, colored when color is true
+func (p *Plan) Print(w io.Writer, color bool) {
+	paint := func(c, s string) string {
+		if !color {
+			return s
+		}
+		return c + s + colorReset
+	}
+	for _, c := range p.Changes {
+		suffix := ""
+		if c.Dir {
+			suffix = " (dir)"
+		}
+		switch c.Type {
+		case changeAdd:
+			fmt.Fprintln(w, paint(colorGreen, fmt.Sprintf("+ %s: %s%s", c.Key, c.New, suffix)))
+		case changeUpdate:
+			if !isLongJSON(c.Old) && !isLongJSON(c.New) {
+				fmt.Fprintln(w, paint(colorYellow, fmt.Sprintf("~ %s: %s -> %s%s", c.Key, c.Old, c.New, suffix)))
+				break
+			}
+			fmt.Fprintln(w, paint(colorYellow, fmt.Sprintf("~ %s:%s", c.Key, suffix)))
+			for _, l := range unifiedDiff(prettyJSON(c.Old), prettyJSON(c.New)) {
+				switch l[0] {
+				case '-':
+					l = paint(colorRed, l)
+				case '+':
+					l = paint(colorGreen, l)
+				}
+				fmt.Fprintln(w, "    "+l)
+			}
+		case changeDelete:
+			fmt.Fprintln(w, paint(colorRed, fmt.Sprintf("- %s: %s%s", c.Key, c.Old, suffix)))
+		}
+	}
+	for _, d := range p.DelDirs {
+		fmt.Fprintln(w, paint(colorRed, fmt.Sprintf("- %s/ (dir)", d)))
+	}
+}
+
+// longJSON is the length from which json values are shown as a line diff
+const longJSON = 80
+
+func isLongJSON(val string) bool {
+	if len(val) < longJSON {
+		return false
+	}
+	val = strings.TrimSpace(val)
+	return (strings.HasPrefix(val, "{") || strings.HasPrefix(val, "[")) && json.Valid([]byte(val))
+}
+
+func prettyJSON(val string) string {
+	buf := &bytes.Buffer{}
+	if err := json.Indent(buf, []byte(val), "", "  "); err != nil {
+		return val
+	}
+	return buf.String()
+}
+
+// unifiedDiff returns the lines of a line diff between a and b, prefixed by
+// "-", "+" or " ", keeping 3 lines of context around each change
+func unifiedDiff(a, b string) []string {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	n, m := len(al), len(bl)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if al[i] == bl[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && al[i] == bl[j]:
+			lines = append(lines, "  "+al[i])
+			i++
+			j++
+		case j >= m || (i < n && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+al[i])
+			i++
+		default:
+			lines = append(lines, "+ "+bl[j])
+			j++
+		}
+	}
+
+	const context = 3
+	keep := make([]bool, len(lines))
+	for idx, l := range lines {
+		if l[0] == ' ' {
+			continue
+		}
+		for k := idx - context; k <= idx+context; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	var ret []string
+	for idx, l := range lines {
+		if !keep[idx] {
+			continue
+		}
+		if idx > 0 && !keep[idx-1] {
+			ret = append(ret, "@@")
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// isTerminal reports whether f is attached to a terminal, used to decide
+// whether diffs are colored
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffKvs(t *testing.T) {
+	before := map[string]string{
+		"/a/b":   "1",
+		"/a/c":   "2",
+		"/a/d/e": "3",
+		"/a/de":  "4",
+	}
+	after := map[string]string{}
+	for k, v := range before {
+		after[k] = v
+	}
+	deleteTree(after, "/a/d")
+	after["/a/c"] = "5"
+	after["/a/f"] = "6"
+
+	assert.Equal(t, []Change{
+		{Type: changeUpdate, Key: "/a/c", Old: "2", New: "5"},
+		{Type: changeDelete, Key: "/a/d/e", Old: "3"},
+		{Type: changeAdd, Key: "/a/f", New: "6"},
+	}, diffKvs(before, after))
+	assert.Empty(t, diffKvs(before, before))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9"
+	b := "1\n2\n3\n4\n5\nsix\n7\n8\n9\n10"
+	assert.Equal(t, []string{
+		"@@", "  3", "  4", "  5", "- 6", "+ six", "  7", "  8", "  9", "+ 10",
+	}, unifiedDiff(a, b))
+	assert.Empty(t, unifiedDiff(a, a))
+}
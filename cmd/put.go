@@ -15,9 +15,10 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/Guazi-inc/etcd-tool/client"
@@ -30,7 +31,7 @@ import (
 // putCmd represents the put command
 var putCmd = &cobra.Command{
 	Use:   "put",
-	Short: "put data from json file",
+	Short: "put data from json, yaml, toml, properties or dotenv file",
 	Long: `A longer description that spans multiple lines and likely contains examples
 and usage of using your command. For example:
 
@@ -39,6 +40,9 @@ This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := putArg.Run(); err != nil {
+			if err == errPlanNotEmpty {
+				os.Exit(2)
+			}
 			logrus.Errorf("got err: %s\n", err.Error())
 			os.Exit(1)
 		}
@@ -50,16 +54,33 @@ var putArg = PutArg{
 }
 
 type PutArg struct {
-	Conf     string
-	Dsn      string
-	Cfg      *client.Config
-	DirValue string
-	Dirs     []string
-	Kvs      map[string]string
-	DelDirs  []string
-	DelKeys  []string
+	Conf       []string
+	Format     string
+	Force      bool
+	ShowMerged bool
+	NoInterp   bool
+	Schema     string
+	Dsn        string
+	Cfg        *client.Config
+	DirValue   string
+	Dirs       []string
+	Kvs        map[string]string
+	DelDirs    []string
+	DelKeys    []string
+	DryRun     bool
+	Json       bool
+	MaxTxnOps  int
+	PageSize   int
+	Guard      bool
+	Prune      bool
+	MaxDeletes int
+	Lock       LockOptions
 }
 
+// errPlanNotEmpty is returned by a dry run which found changes, so scripts
+// can tell it apart from a failure
+var errPlanNotEmpty = errors.New("plan is not empty")
+
 func init() {
 	RootCmd.AddCommand(putCmd)
 
@@ -71,116 +92,216 @@ func init() {
 
 	// Cobra supports local flags which will only run when this command
 	// is called directly, e.g.:
-	putCmd.Flags().StringVarP(&putArg.Conf, "conf", "c", "", "configure file")
+	putCmd.Flags().StringSliceVarP(&putArg.Conf, "conf", "c", nil, "configure files or dirs, later ones are deep merged over earlier ones")
+	putCmd.Flags().StringVar(&putArg.Format, "format", "", "format of the configure file: json, yaml, toml, properties or dotenv, default by file extension")
 	putCmd.Flags().StringVarP(&putArg.Dsn, "etcd", "e", "", "etcd address")
 	putCmd.Flags().StringVarP(&putArg.DirValue, "dir_value", "d", "", "dir value")
+	putCmd.Flags().BoolVar(&putArg.DryRun, "dry-run", false, "only print the changes, exit with 2 if there are any")
+	putCmd.Flags().BoolVar(&putArg.Json, "json", false, "print the dry run plan as json")
+	putCmd.Flags().IntVar(&putArg.MaxTxnOps, "max-txn-ops", client.DefaultMaxTxnOps, "max operations per transaction")
+	putCmd.Flags().IntVar(&putArg.PageSize, "page-size", client.DefaultPageSize, "keys read per request")
+	putCmd.Flags().BoolVar(&putArg.Guard, "guard", false, "fail if keys under the path, or the dirs above it, were modified after they were read")
+	putCmd.Flags().BoolVar(&putArg.Prune, "prune", false, "delete the keys under the path which are not in the configure files")
+	putCmd.Flags().IntVar(&putArg.MaxDeletes, "max-deletes", 0, "abort if --prune would delete more than this many keys, 0 for no limit")
+	putCmd.Flags().BoolVar(&putArg.Force, "force", false, "let a map and a value override each other when merging configure files")
+	putCmd.Flags().StringVar(&putArg.Schema, "schema", "", "json schema to validate the configure files with, default is the "+schemaKey+" key under the path")
+	addLockFlags(putCmd, &putArg.Lock)
+	putCmd.Flags().BoolVar(&putArg.ShowMerged, "show-merged", false, "only print the merged configure files")
+	putCmd.Flags().BoolVar(&putArg.NoInterp, "no-interpolate", false, "write ${...} expressions as they are instead of expanding environment variables and files")
 
 }
 
 func (p *PutArg) Run() error {
+	confMap, err := loadConfs(p.Conf, p.Format, p.Force)
+	if err != nil {
+		return err
+	}
+	if !p.NoInterp {
+		if _, err := interpolate(confMap, ""); err != nil {
+			return err
+		}
+	}
+	if p.ShowMerged {
+		buf, err := marshalIndent(confMap)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+
 	p.Cfg = client.ParseDSN(p.Dsn)
 	if p.Cfg == nil {
 		return errors.New("invalid params")
 	}
 	logrus.Infof("ETCD ADDRESS: %s", putArg.Cfg.Addrs)
 
-	bytes, err := ioutil.ReadFile(p.Conf)
-	if err != nil {
+	if err := p.parseKeyValue(confMap, p.Cfg.Path); err != nil {
 		return err
 	}
-	confMap := map[string]interface{}{}
-	err = jsoniter.Unmarshal(bytes, &confMap)
+
+	p.Dirs = append(p.Dirs, getDirs(p.Cfg.Path)...)
+
+	cli, err := client.NewClient(p.Dsn)
 	if err != nil {
 		return err
 	}
-	if err := p.parseKeyValue(confMap, p.Cfg.Path); err != nil {
+	schema, err := loadSchema(cli, p.Schema, p.Cfg.Path)
+	if err != nil {
 		return err
 	}
+	if schema != nil {
+		if err := validateDoc(schema, docWithoutSchema(storedDoc(confMap))); err != nil {
+			return err
+		}
+	}
 
-	p.Dirs = append(p.Dirs, getDirs(p.Cfg.Path)...)
+	ctx, stop := signalContext()
+	defer stop()
+	if !p.DryRun {
+		unlock, err := p.Lock.lock(ctx, cli, p.Cfg.Path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
 
-	cli, err := client.NewClient(p.Dsn)
+	plan, err := p.plan(ctx, cli)
 	if err != nil {
 		return err
 	}
-
-	var errDelKvDirs []string
-	var errKvs = map[string]string{}
-	for k, v := range p.Kvs {
-		if err = cli.DeleteWithPrefix(k); err != nil {
-			errDelKvDirs = append(errDelKvDirs, k)
+	if p.DryRun {
+		if p.Json {
+			buf, err := marshalIndent(plan)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(buf))
+		} else {
+			plan.Print(os.Stdout, isTerminal(os.Stdout))
+			fmt.Printf("plan: %s\n", plan.Summary())
 		}
-		if err = cli.Put(k, v); err != nil {
-			errKvs[k] = v
+		if !plan.Empty() {
+			return errPlanNotEmpty
 		}
+		return nil
+	}
+
+	audit, parts, err := auditOps(plan.Changes)
+	if err != nil {
+		return err
+	}
+	commitOpts := client.CommitOptions{
+		MaxOps: p.MaxTxnOps,
+		Rev:    plan.Rev,
+		Always: audit,
 	}
+	if p.Guard {
+		commitOpts.GuardPrefix = p.Cfg.Path
+		commitOpts.GuardKeys = getDirs(p.Cfg.Path)
+	}
+	rev, err := cli.CommitCtx(ctx, append(plan.Ops(), parts...), commitOpts)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("put %d key, %s, all success at revision %d", len(p.Kvs), plan.Summary(), rev)
+	return nil
+}
 
-	if len(errDelKvDirs) > 0 {
-		logrus.Errorf("delete dir which should be key, %d fail, %+v", len(errDelKvDirs), errDelKvDirs)
-		os.Exit(1)
+// plan reads the live keys and replays the put on a copy of them: dirs which
+// should be keys and keys which should be dirs are removed, keys and dir
+// placeholders are written, then empty dirs and keys are deleted
+func (p *PutArg) plan(ctx context.Context, cli *client.Client) (*Plan, error) {
+	before, rev, err := cli.GetWithPrefixPaged(ctx, p.Cfg.Path, p.PageSize)
+	if err != nil {
+		return nil, err
 	}
-	if len(errKvs) > 0 {
-		logrus.Errorf("put %d key, %d fail, %+v", len(p.Kvs), len(errKvs), errKvs)
-		os.Exit(1)
-	} else {
-		logrus.Infof("put %d key, all success", len(p.Kvs))
+	// the dirs above the path are read at the same revision, --guard also
+	// checks them
+	for _, d := range getDirs(p.Cfg.Path) {
+		val, err := cli.GetCtx(ctx, d, client.GetOptions{Revision: rev})
+		if err != nil {
+			return nil, err
+		}
+		if val != "" {
+			before[d] = val
+		}
 	}
 
-	var errDelDirKeys []string
+	after := map[string]string{}
+	for k, v := range before {
+		after[k] = v
+	}
+	for k := range p.Kvs {
+		deleteTree(after, k)
+	}
 	for _, d := range p.Dirs {
-		if err = cli.Delete(d); err != nil {
-			errDelDirKeys = append(errDelDirKeys, d)
-		}
+		delete(after, d)
 	}
-	if len(errDelDirKeys) > 0 {
-		logrus.Errorf("delete key which should be dir, %d fail, %+v", len(errDelDirKeys), errDelDirKeys)
-		os.Exit(1)
+	for k, v := range p.Kvs {
+		after[k] = v
 	}
 	if p.DirValue != "" {
-		var errDirs []string
 		for _, d := range p.Dirs {
-			if err = cli.Put(d, p.DirValue); err != nil {
-				errDirs = append(errDirs, d)
-			}
+			after[d] = p.DirValue
 		}
-		if len(errDirs) > 0 {
-			logrus.Errorf("create %d dir, %d fail, %+v", len(p.Dirs), len(errDirs), errDirs)
-			os.Exit(1)
-		} else {
-			logrus.Infof("create %d dir, all success", len(p.Dirs))
+	}
+	for _, d := range p.DelDirs {
+		deleteTree(after, d)
+	}
+	for _, k := range p.DelKeys {
+		deleteTree(after, k)
+	}
+	if p.Prune {
+		if err := p.prune(after); err != nil {
+			return nil, err
 		}
 	}
 
-	if len(p.DelDirs) > 0 {
-		var errDel []string
-		for _, d := range p.DelDirs {
-			if err = cli.DeleteWithPrefix(d); err != nil {
-				errDel = append(errDel, d)
+	plan := &Plan{Changes: diffKvs(before, after), Rev: rev}
+	dirs := map[string]bool{}
+	for _, d := range p.Dirs {
+		dirs[d] = true
+	}
+	for i := range plan.Changes {
+		plan.Changes[i].Dir = dirs[plan.Changes[i].Key]
+	}
+	for _, d := range p.DelDirs {
+		for _, c := range plan.Changes {
+			if c.Type == changeDelete && strings.HasPrefix(c.Key, d+delimiter) {
+				plan.DelDirs = append(plan.DelDirs, d)
+				break
 			}
 		}
-		if len(errDel) > 0 {
-			logrus.Errorf("delete %d dir, %d fail, %+v", len(p.DelDirs), len(errDel), errDel)
-			os.Exit(1)
-		} else {
-			logrus.Infof("delete %d dir, all success", len(p.DelDirs))
-		}
 	}
+	sort.Strings(plan.DelDirs)
+	return plan, nil
+}
 
-	if len(p.DelKeys) > 0 {
-		var errDel []string
-		for _, d := range p.DelKeys {
-			if err := cli.DeleteWithPrefix(d); err != nil {
-				errDel = append(errDel, d)
-			}
+// prune deletes from after the keys under the path which the configure files
+// don't produce, dir placeholders are kept when --dir_value is set and
+// reserved keys and the schema are always kept
+func (p *PutArg) prune(after map[string]string) error {
+	keep := map[string]bool{joinKey(p.Cfg.Path, schemaKey): true}
+	if p.DirValue != "" {
+		for _, d := range p.Dirs {
+			keep[d] = true
 		}
-		if len(errDel) > 0 {
-			logrus.Errorf("delete %d key, %d fail, %+v", len(p.DelKeys), len(errDel), errDel)
-			os.Exit(1)
-		} else {
-			logrus.Infof("delete %d key, all success", len(p.DelKeys))
+	}
+	prefix := strings.TrimSuffix(p.Cfg.Path, delimiter) + delimiter
+	var pruned []string
+	for k := range after {
+		if _, ok := p.Kvs[k]; ok || keep[k] || isReserved(k) || !strings.HasPrefix(k, prefix) {
+			continue
 		}
+		pruned = append(pruned, k)
+	}
+	if p.MaxDeletes > 0 && len(pruned) > p.MaxDeletes {
+		return errors.Errorf("prune would delete %d keys, more than --max-deletes %d", len(pruned), p.MaxDeletes)
+	}
+	for _, k := range pruned {
+		delete(after, k)
 	}
-
 	return nil
 }
 
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutArg_prune(t *testing.T) {
+	p := PutArg{
+		Cfg:      &client.Config{Path: "/app"},
+		Kvs:      map[string]string{"/app/a": "1"},
+		Dirs:     []string{"/app", "/app/dir"},
+		DirValue: "dir",
+	}
+	after := map[string]string{
+		"/app":          "dir",
+		"/app/a":        "1",
+		"/app/dir":      "dir",
+		"/app/old":      "x",
+		"/app/old/deep": "y",
+		"/application":  "z",
+	}
+	assert.Nil(t, p.prune(after))
+	assert.Equal(t, map[string]string{
+		"/app":         "dir",
+		"/app/a":       "1",
+		"/app/dir":     "dir",
+		"/application": "z",
+	}, after)
+
+	p.MaxDeletes = 1
+	after["/app/old"] = "x"
+	after["/app/other"] = "x"
+	assert.NotNil(t, p.prune(after))
+	assert.Equal(t, "x", after["/app/old"])
+}
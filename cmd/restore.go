@@ -0,0 +1,142 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "load an archive written by backup",
+	Long: `Verify an archive written by "backup" and write its keys under the dsn
+path, which may differ from the path it was taken from. Keys with a ttl get a
+new lease of the same ttl.
+
+Example:
+  etcd-tool restore -i my_project.json.gz -e localhost:2379/my_project --prune`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := restoreArg.Run(); err != nil {
+			if err == errPlanNotEmpty {
+				os.Exit(2)
+			}
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var restoreArg RestoreArg
+
+type RestoreArg struct {
+	Dsn       string
+	Input     string
+	Prune     bool
+	DryRun    bool
+	MaxTxnOps int
+}
+
+func init() {
+	RootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVarP(&restoreArg.Dsn, "etcd", "e", "", "etcd address")
+	restoreCmd.Flags().StringVarP(&restoreArg.Input, "input", "i", "", "archive file, - for stdin")
+	restoreCmd.Flags().BoolVar(&restoreArg.Prune, "prune", false, "delete keys under the path which are not in the archive")
+	restoreCmd.Flags().BoolVar(&restoreArg.DryRun, "dry-run", false, "only print the changes, exit with 2 if there are any")
+	restoreCmd.Flags().IntVar(&restoreArg.MaxTxnOps, "max-txn-ops", client.DefaultMaxTxnOps, "max operations per transaction")
+}
+
+func (r *RestoreArg) Run() error {
+	cfg := client.ParseDSN(r.Dsn)
+	if cfg == nil || r.Input == "" {
+		return errors.New("invalid params")
+	}
+	archive, err := readArchive(r.Input)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("restore %d key of %s taken at revision %d to %s", len(archive.Kvs), archive.Path, archive.Revision, cfg.Path)
+
+	cli, err := client.NewClient(r.Dsn)
+	if err != nil {
+		return err
+	}
+	before, rev, err := cli.GetWithPrefixRev(cfg.Path)
+	if err != nil {
+		return err
+	}
+	after := map[string]string{}
+	for k, v := range before {
+		if !r.Prune || isReserved(k) {
+			after[k] = v
+		}
+	}
+	ttls := map[string]int64{}
+	for _, kv := range archive.Kvs {
+		k := cfg.Path + kv.Key
+		after[k] = string(kv.Value)
+		if kv.TTL > 0 {
+			ttls[k] = kv.TTL
+		}
+	}
+
+	plan := &Plan{Changes: diffKvs(before, after), Rev: rev}
+	if r.DryRun {
+		plan.Print(os.Stdout, isTerminal(os.Stdout))
+		fmt.Printf("plan: %s\n", plan.Summary())
+		if !plan.Empty() {
+			return errPlanNotEmpty
+		}
+		return nil
+	}
+
+	// keys sharing a ttl share a lease
+	leases := map[int64]int64{}
+	ops := make([]clientv3.Op, 0, len(plan.Changes))
+	for _, c := range plan.Changes {
+		if c.Type == changeDelete {
+			ops = append(ops, clientv3.OpDelete(c.Key))
+			continue
+		}
+		ttl, ok := ttls[c.Key]
+		if !ok {
+			ops = append(ops, clientv3.OpPut(c.Key, c.New))
+			continue
+		}
+		if _, ok := leases[ttl]; !ok {
+			if leases[ttl], err = cli.Grant(ttl); err != nil {
+				return err
+			}
+		}
+		ops = append(ops, clientv3.OpPut(c.Key, c.New, clientv3.WithLease(clientv3.LeaseID(leases[ttl]))))
+	}
+	audit, parts, err := auditOps(plan.Changes)
+	if err != nil {
+		return err
+	}
+	if rev, err = cli.Commit(append(ops, parts...), r.MaxTxnOps, cfg.Path, rev, audit...); err != nil {
+		return err
+	}
+	logrus.Infof("restore %s, all success at revision %d", plan.Summary(), rev)
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback [path]",
+	Short: "restore the values a path had at a past revision",
+	Long: `Read the keys under the dsn path (optionally narrowed by [path]) as they
+were at --to-revision, print the changes against the current values and apply
+them in transactions.
+
+etcd keeps no timestamps, so --to-time is the revision of the last change the
+audit log recorded at or before it. Writes made by other tools are not in the
+audit log, for them give --clock-key, a key refreshed with RFC3339 or unix
+timestamps, e.g. by "etcdctl put /_etcd_tool/clock $(date +%s)" in a cron job
+every --clock-interval, and --to-time bisects the revisions on its history.
+
+Example:
+  etcd-tool rollback -e localhost:2379/my_project /redis --to-revision 1024`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := rollbackArg.Run(args); err != nil {
+			if err == errPlanNotEmpty {
+				os.Exit(2)
+			}
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var rollbackArg RollbackArg
+
+type RollbackArg struct {
+	Dsn        string
+	ToRevision int64
+	ToTime     string
+	ClockKey   string
+	// ClockInterval is how often ClockKey is refreshed
+	ClockInterval time.Duration
+	DryRun        bool
+	MaxTxnOps     int
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().StringVarP(&rollbackArg.Dsn, "etcd", "e", "", "etcd address")
+	rollbackCmd.Flags().Int64Var(&rollbackArg.ToRevision, "to-revision", 0, "revision to roll back to")
+	rollbackCmd.Flags().StringVar(&rollbackArg.ToTime, "to-time", "", "time to roll back to, RFC3339 or unix seconds")
+	rollbackCmd.Flags().StringVar(&rollbackArg.ClockKey, "clock-key", "", "key holding timestamps to resolve --to-time with instead of the audit log, e.g. "+reservedPrefix+"clock")
+	rollbackCmd.Flags().DurationVar(&rollbackArg.ClockInterval, "clock-interval", time.Minute, "how often --clock-key is refreshed, --to-time fails if the clock found is further from it")
+	rollbackCmd.Flags().BoolVar(&rollbackArg.DryRun, "dry-run", false, "only print the changes, exit with 2 if there are any")
+	rollbackCmd.Flags().IntVar(&rollbackArg.MaxTxnOps, "max-txn-ops", client.DefaultMaxTxnOps, "max operations per transaction")
+}
+
+func (r *RollbackArg) Run(args []string) error {
+	cfg := client.ParseDSN(r.Dsn)
+	if cfg == nil || len(args) > 1 || (r.ToRevision == 0) == (r.ToTime == "") {
+		return errors.New("invalid params, need one of --to-revision and --to-time")
+	}
+	prefix := cfg.Path
+	if len(args) == 1 {
+		prefix = joinKey(cfg.Path, args[0]) + delimiter
+	}
+
+	cli, err := client.NewClient(r.Dsn)
+	if err != nil {
+		return err
+	}
+	oldest, current, err := cli.Revisions()
+	if err != nil {
+		return err
+	}
+	if r.ToTime != "" {
+		t, err := parseTime(r.ToTime)
+		if err != nil {
+			return err
+		}
+		if r.ClockKey != "" {
+			r.ToRevision, err = r.revisionAt(cli, t, oldest, current)
+		} else {
+			r.ToRevision, err = auditRevisionAt(cli, t, oldest)
+		}
+		if err != nil {
+			return err
+		}
+		logrus.Infof("%s resolved to revision %d", r.ToTime, r.ToRevision)
+	}
+	if r.ToRevision > current {
+		return errors.Errorf("revision %d is in the future, current revision is %d", r.ToRevision, current)
+	}
+
+	past, err := cli.GetWithPrefixAt(prefix, r.ToRevision)
+	if err == rpctypes.ErrCompacted {
+		return errors.Errorf("revision %d has been compacted, the oldest available is %d", r.ToRevision, oldest)
+	}
+	if err != nil {
+		return err
+	}
+	now, rev, err := cli.GetWithPrefixRev(prefix)
+	if err != nil {
+		return err
+	}
+
+	// the audit log, locks and copy states are not rolled back
+	plan := &Plan{Changes: diffKvs(dropReserved(now), dropReserved(past)), Rev: rev}
+	plan.Print(os.Stdout, isTerminal(os.Stdout))
+	fmt.Printf("plan: %s\n", plan.Summary())
+	if r.DryRun {
+		if !plan.Empty() {
+			return errPlanNotEmpty
+		}
+		return nil
+	}
+	audit, parts, err := auditOps(plan.Changes)
+	if err != nil {
+		return err
+	}
+	if rev, err = cli.Commit(append(plan.Ops(), parts...), r.MaxTxnOps, prefix, rev, audit...); err != nil {
+		return err
+	}
+	logrus.Infof("rollback %s to revision %d, all success at revision %d", prefix, r.ToRevision, rev)
+	return nil
+}
+
+// auditRevisionAt returns the revision of the last change the audit log
+// recorded at or before t
+func auditRevisionAt(cli *client.Client, t time.Time, oldest int64) (int64, error) {
+	var found *AuditEntry
+	err := loadAudit(cli, 0, func(e *AuditEntry) (bool, error) {
+		if e.Time.After(t) {
+			return true, nil
+		}
+		found = e
+		return false, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if found == nil {
+		return 0, errors.Errorf("no change was recorded in the audit log at or before %s, give --to-revision or --clock-key", t.Format(time.RFC3339))
+	}
+	if found.Revision < oldest {
+		return 0, errors.Errorf("revision %d of the change at %s has been compacted, the oldest available is %d", found.Revision, found.Time.Format(time.RFC3339), oldest)
+	}
+	return found.Revision, nil
+}
+
+// revisionAt returns the last revision at which the clock key was not after
+// t. The clock must have a value at the oldest revision and the one found
+// must be within ClockInterval of t, else the clock can't tell.
+func (r *RollbackArg) revisionAt(cli *client.Client, t time.Time, oldest, current int64) (int64, error) {
+	clock := func(rev int64) (time.Time, error) {
+		val, err := cli.GetAt(r.ClockKey, rev)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if val == "" {
+			return time.Time{}, errors.Errorf("clock %s has no value at revision %d", r.ClockKey, rev)
+		}
+		ct, err := parseTime(val)
+		if err != nil {
+			return time.Time{}, errors.Errorf("invalid clock %s at revision %d: %s", val, rev, err.Error())
+		}
+		return ct, nil
+	}
+
+	ct, err := clock(oldest)
+	if err != nil {
+		return 0, err
+	}
+	if ct.After(t) {
+		return 0, errors.Errorf("revisions before %s have been compacted, the oldest available is %d", t.Format(time.RFC3339), oldest)
+	}
+	lo, hi := oldest, current
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		if ct, err = clock(mid); err != nil {
+			return 0, err
+		}
+		if !ct.After(t) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	if ct, err = clock(lo); err != nil {
+		return 0, err
+	}
+	if t.Sub(ct) > r.ClockInterval {
+		return 0, errors.Errorf("clock %s is %s at revision %d, more than --clock-interval %s before %s", r.ClockKey, ct.Format(time.RFC3339), lo, r.ClockInterval, t.Format(time.RFC3339))
+	}
+	return lo, nil
+}
+
+// parseTime accepts RFC3339, "2006-01-02 15:04:05" in local time and unix
+// seconds
+func parseTime(val string) (time.Time, error) {
+	val = strings.TrimSpace(val)
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02 15:04:05", val, time.Local)
+}
@@ -17,14 +17,21 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
 	homedir "github.com/mitchellh/go-homedir"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 const (
 	delimiter = "/"
+	// reservedPrefix holds the keys etcd-tool writes for itself
+	reservedPrefix = "/_etcd_tool/"
 )
 
 var cfgFile string
@@ -54,18 +61,74 @@ func Execute() {
 }
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	cobra.OnInitialize(initConfig, func() {
+		if err := checkConnOptions(client.DefaultConnOptions); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	})
 
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	//RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.etcd-tool.yaml)")
+	// the dsn parameters of the same names take precedence over these
+	opts := &client.DefaultConnOptions
+	RootCmd.PersistentFlags().DurationVar(&opts.DialTimeout, "dial-timeout", opts.DialTimeout, "etcd dial timeout, dsn parameter dial_timeout")
+	RootCmd.PersistentFlags().DurationVar(&opts.RequestTimeout, "request-timeout", opts.RequestTimeout, "etcd request timeout, dsn parameter request_timeout")
+	RootCmd.PersistentFlags().IntVar(&opts.Retries, "retries", opts.Retries, "etcd dial attempts, dsn parameter retries")
+	RootCmd.PersistentFlags().DurationVar(&opts.RetryInterval, "retry-interval", opts.RetryInterval, "wait between etcd dial attempts, dsn parameter retry_interval")
+	RootCmd.PersistentFlags().DurationVar(&opts.Keepalive, "keepalive", opts.Keepalive, "etcd keepalive ping interval, 0 to disable, dsn parameter keepalive")
+	RootCmd.PersistentFlags().IntVar(&opts.MaxCallRecvSize, "max-call-recv-size", opts.MaxCallRecvSize, "max etcd response size in bytes, 0 for the client default, dsn parameter max_call_recv_size")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	//RootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// checkConnOptions rejects the flag values the dsn parameters of the same
+// names reject
+func checkConnOptions(opts client.ConnOptions) error {
+	durations := []struct {
+		name string
+		val  time.Duration
+	}{
+		{"dial-timeout", opts.DialTimeout},
+		{"request-timeout", opts.RequestTimeout},
+		{"retry-interval", opts.RetryInterval},
+	}
+	for _, d := range durations {
+		if d.val <= 0 {
+			return errors.Errorf("invalid --%s %s, not positive", d.name, d.val)
+		}
+	}
+	if opts.Retries <= 0 {
+		return errors.Errorf("invalid --retries %d, not positive", opts.Retries)
+	}
+	// 0 keeps the default of these two, only a negative value is wrong
+	if opts.Keepalive < 0 {
+		return errors.Errorf("invalid --keepalive %s, negative", opts.Keepalive)
+	}
+	if opts.MaxCallRecvSize < 0 {
+		return errors.Errorf("invalid --max-call-recv-size %d, negative", opts.MaxCallRecvSize)
+	}
+	return nil
+}
+
+func isReserved(key string) bool {
+	return strings.HasPrefix(key, reservedPrefix)
+}
+
+// dropReserved removes the reserved keys from kvs and returns it
+func dropReserved(kvs map[string]string) map[string]string {
+	for k := range kvs {
+		if isReserved(k) {
+			delete(kvs, k)
+		}
+	}
+	return kvs
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
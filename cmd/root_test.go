@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckConnOptions(t *testing.T) {
+	assert.Nil(t, checkConnOptions(client.DefaultConnOptions))
+
+	opts := client.DefaultConnOptions
+	opts.RequestTimeout = 0
+	assert.EqualError(t, checkConnOptions(opts), "invalid --request-timeout 0s, not positive")
+
+	opts = client.DefaultConnOptions
+	opts.DialTimeout = -1
+	assert.EqualError(t, checkConnOptions(opts), "invalid --dial-timeout -1ns, not positive")
+
+	opts = client.DefaultConnOptions
+	opts.Keepalive = 0
+	assert.Nil(t, checkConnOptions(opts))
+	opts.Keepalive = -time.Second
+	assert.EqualError(t, checkConnOptions(opts), "invalid --keepalive -1s, negative")
+
+	opts = client.DefaultConnOptions
+	opts.MaxCallRecvSize = 0
+	assert.Nil(t, checkConnOptions(opts))
+	opts.MaxCallRecvSize = -1
+	assert.EqualError(t, checkConnOptions(opts), "invalid --max-call-recv-size -1, negative")
+
+	opts = client.DefaultConnOptions
+	opts.Retries = 0
+	assert.NotNil(t, checkConnOptions(opts))
+}
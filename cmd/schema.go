@@ -0,0 +1,154 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaKey is the key under a dsn path holding the json schema of the
+// documents put there
+const schemaKey = "_schema"
+
+// compileSchema compiles a json schema, draft-07 unless its $schema tells
+// otherwise, name identifies it in errors
+func compileSchema(name string, buf []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.Draft = jsonschema.Draft7
+	url := "etcd-tool://" + strings.TrimPrefix(name, delimiter)
+	if err := compiler.AddResource(url, bytes.NewReader(buf)); err != nil {
+		return nil, errors.Errorf("schema %s: %s", name, err.Error())
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, errors.Errorf("schema %s: %s", name, err.Error())
+	}
+	return schema, nil
+}
+
+// loadSchema reads the schema from file, or from the schema key of path when
+// file is empty. It returns nil when there is no schema at path.
+func loadSchema(cli *client.Client, file, path string) (*jsonschema.Schema, error) {
+	if file != "" {
+		buf, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return compileSchema(file, buf)
+	}
+	key := joinKey(path, schemaKey)
+	val, err := cli.Get(key)
+	if err != nil || val == "" {
+		return nil, err
+	}
+	return compileSchema(key, []byte(val))
+}
+
+// validateDoc checks doc against schema and reports every violation, one per
+// line with the json pointer of the value
+func validateDoc(schema *jsonschema.Schema, doc interface{}) error {
+	buf, err := marshalCompact(doc)
+	if err != nil {
+		return err
+	}
+	// the schema only accepts the types encoding/json decodes to
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	err = schema.Validate(v)
+	verr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return err
+	}
+	violations := schemaViolations(verr)
+	return errors.Errorf("%d schema violations:\n  %s", len(violations), strings.Join(violations, "\n  "))
+}
+
+// schemaViolations returns the leaves of a validation error, the causes which
+// have no causes themselves
+func schemaViolations(verr *jsonschema.ValidationError) []string {
+	if len(verr.Causes) == 0 {
+		ptr := verr.InstanceLocation
+		if ptr == "" {
+			ptr = "/"
+		}
+		return []string{fmt.Sprintf("%s: %s", ptr, verr.Message)}
+	}
+	var violations []string
+	seen := map[string]bool{}
+	for _, cause := range verr.Causes {
+		for _, v := range schemaViolations(cause) {
+			if !seen[v] {
+				seen[v] = true
+				violations = append(violations, v)
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations
+}
+
+// docWithoutSchema returns the document with the schema key of its root
+// removed, so a live dir validates without it
+func docWithoutSchema(doc map[string]interface{}) map[string]interface{} {
+	if _, ok := doc[schemaKey]; !ok {
+		return doc
+	}
+	ret := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if k != schemaKey {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// storedDoc returns doc as it reads back from etcd once put, so configure
+// files and the live dir validate alike: strings are decoded like buildTree
+// does, "8080" becomes a number, and the empty values put deletes are left out
+func storedDoc(doc map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		switch val := v.(type) {
+		case map[string]interface{}:
+			if len(val) > 0 {
+				ret[k] = storedDoc(val)
+			}
+		case []interface{}:
+			if len(val) > 0 {
+				ret[k] = val
+			}
+		case string:
+			if val != "" {
+				ret[k] = decodeValue(val)
+			}
+		default:
+			ret[k] = v
+		}
+	}
+	return ret
+}
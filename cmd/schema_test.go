@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDoc(t *testing.T) {
+	schema, err := compileSchema("/app/_schema", []byte(`{
+		"type": "object",
+		"required": ["redis"],
+		"properties": {
+			"redis": {
+				"type": "object",
+				"properties": {
+					"port": {"type": "integer"},
+					"hosts": {"type": "array", "items": {"type": "string"}}
+				},
+				"additionalProperties": false
+			}
+		}
+	}`))
+	assert.Nil(t, err)
+
+	doc, err := parseConf([]byte(`{"redis": {"port": 6379, "hosts": ["a"]}}`), formatJSON)
+	assert.Nil(t, err)
+	assert.Nil(t, validateDoc(schema, doc))
+
+	doc, err = parseConf([]byte("redis:\n  port: abc\n  hosts: [a, 1]\n  typo: x\n"), formatYAML)
+	assert.Nil(t, err)
+	err = validateDoc(schema, doc)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "3 schema violations")
+	assert.Contains(t, err.Error(), "/redis/port: expected integer, but got string")
+	assert.Contains(t, err.Error(), "/redis/hosts/1: expected string, but got number")
+	assert.Contains(t, err.Error(), "/redis: additionalProperties 'typo' not allowed")
+
+	_, err = compileSchema("bad.json", []byte(`{"type": 1}`))
+	assert.NotNil(t, err)
+}
+
+func TestStoredDoc(t *testing.T) {
+	schema, err := compileSchema("/app/_schema", []byte(`{
+		"type": "object",
+		"properties": {"port": {"type": "integer"}},
+		"required": ["port"]
+	}`))
+	assert.Nil(t, err)
+
+	doc, err := parseConf([]byte(`{"port": "8080", "name": "", "hosts": [], "tls": {}}`), formatJSON)
+	assert.Nil(t, err)
+	stored := storedDoc(doc)
+	assert.Nil(t, validateDoc(schema, stored))
+	assert.Equal(t, buildTree("/app", map[string]string{"/app/port": "8080"}), stored)
+
+	doc, err = parseConf([]byte(`{"port": ""}`), formatJSON)
+	assert.Nil(t, err)
+	assert.NotNil(t, validateDoc(schema, storedDoc(doc)))
+}
+
+func TestDocWithoutSchema(t *testing.T) {
+	schema, err := compileSchema("/app/_schema", []byte(`{
+		"type": "object",
+		"properties": {"port": {"type": "integer"}},
+		"additionalProperties": false
+	}`))
+	assert.Nil(t, err)
+
+	// a configure file can carry the schema of its dir along with the values
+	doc, err := parseConf([]byte(`{"port": 8080, "_schema": "{\"type\": \"object\"}"}`), formatJSON)
+	assert.Nil(t, err)
+	assert.NotNil(t, validateDoc(schema, storedDoc(doc)))
+	assert.Nil(t, validateDoc(schema, docWithoutSchema(storedDoc(doc))))
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+)
+
+// signalContext returns a context cancelled on the first SIGINT or SIGTERM,
+// so a long command stops between requests and releases its lock on the way
+// out. A second signal exits at once. stop ends the handling.
+func signalContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case s := <-sig:
+			logrus.Warnf("got signal %s, stopping, signal again to exit at once", s)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case s := <-sig:
+			logrus.Errorf("got signal %s, exit", s)
+			os.Exit(1)
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(sig)
+		close(done)
+		cancel()
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// treeCmd represents the tree command
+var treeCmd = &cobra.Command{
+	Use:   "tree [path]",
+	Short: "print the key hierarchy under a path",
+	Long: `Print the dirs and keys under the dsn path, optionally narrowed by [path],
+with the number of keys of every dir. Only keys are read, unless --bytes asks
+for the size of the values.
+
+Example:
+  etcd-tool tree -e localhost:2379/my_project -L 2 --bytes`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := treeArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var treeArg TreeArg
+
+type TreeArg struct {
+	Dsn   string
+	Depth int
+	Bytes bool
+}
+
+func init() {
+	RootCmd.AddCommand(treeCmd)
+
+	treeCmd.Flags().StringVarP(&treeArg.Dsn, "etcd", "e", "", "etcd address")
+	treeCmd.Flags().IntVarP(&treeArg.Depth, "depth", "L", 0, "max depth to print, 0 for no limit")
+	treeCmd.Flags().BoolVar(&treeArg.Bytes, "bytes", false, "read the values to print their total size")
+}
+
+func (t *TreeArg) Run(args []string) error {
+	cfg := client.ParseDSN(t.Dsn)
+	if cfg == nil || len(args) > 1 {
+		return errors.New("invalid params")
+	}
+	key := strings.TrimSuffix(cfg.Path, delimiter)
+	if len(args) == 1 {
+		key = joinKey(cfg.Path, args[0])
+	}
+	cli, err := client.NewClient(t.Dsn)
+	if err != nil {
+		return err
+	}
+	root, err := loadKeyTree(cli, key, t.Bytes)
+	if err != nil {
+		return err
+	}
+	root.print(os.Stdout, key+delimiter, "", t.Depth, t.Bytes)
+	return nil
+}
+
+// keyNode is a dir or a key of the hierarchy, a dir placeholder is both
+type keyNode struct {
+	children map[string]*keyNode
+	leaf     bool
+	// keys and bytes count the keys at and under the node and their values
+	keys  int
+	bytes int64
+}
+
+// loadKeyTree reads the keys under key, with the size of their values when
+// withBytes is set, and builds their hierarchy with the rules of buildTree
+func loadKeyTree(cli *client.Client, key string, withBytes bool) (*keyNode, error) {
+	sizes := map[string]int64{}
+	if withBytes {
+		kvs, err := cli.GetWithPrefix(key + delimiter)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range kvs {
+			sizes[k] = int64(len(v))
+		}
+	} else {
+		keys, err := cli.Keys(key + delimiter)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			sizes[k] = 0
+		}
+	}
+	return newKeyTree(key, sizes), nil
+}
+
+func newKeyTree(baseKey string, sizes map[string]int64) *keyNode {
+	if !strings.HasSuffix(baseKey, delimiter) {
+		baseKey = baseKey + delimiter
+	}
+	root := &keyNode{}
+	for key, size := range sizes {
+		k := strings.TrimPrefix(key, baseKey)
+		if !strings.HasPrefix(key, baseKey) || k == "" || strings.Contains(k, "//") || strings.HasSuffix(k, delimiter) {
+			continue
+		}
+		node := root
+		for _, name := range strings.Split(k, delimiter) {
+			node.keys++
+			node.bytes += size
+			if node.children == nil {
+				node.children = map[string]*keyNode{}
+			}
+			if node.children[name] == nil {
+				node.children[name] = &keyNode{}
+			}
+			node = node.children[name]
+		}
+		node.leaf = true
+		node.keys++
+		node.bytes += size
+	}
+	return root
+}
+
+func (n *keyNode) isDir() bool {
+	return len(n.children) > 0
+}
+
+// names returns the children sorted, dirs with a trailing delimiter
+func (n *keyNode) names() []string {
+	var names []string
+	for name, child := range n.children {
+		if child.isDir() {
+			name += delimiter
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// print writes the node and its children down to depth levels, 0 for all
+func (n *keyNode) print(w io.Writer, name, indent string, depth int, withBytes bool) {
+	fmt.Fprintln(w, name+n.summary(withBytes))
+	if depth == 1 {
+		return
+	}
+	names := n.names()
+	for i, childName := range names {
+		branch, next := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, next = "└── ", "    "
+		}
+		fmt.Fprint(w, indent+branch)
+		n.children[strings.TrimSuffix(childName, delimiter)].print(w, childName, indent+next, depth-1, withBytes)
+	}
+}
+
+func (n *keyNode) summary(withBytes bool) string {
+	var parts []string
+	if n.isDir() {
+		unit := "keys"
+		if n.keys == 1 {
+			unit = "key"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s", n.keys, unit))
+	}
+	if withBytes {
+		parts = append(parts, formatBytes(n.bytes))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
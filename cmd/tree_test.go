@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyTree(t *testing.T) {
+	root := newKeyTree("/app", map[string]int64{
+		"/app":               3,
+		"/app/name":          4,
+		"/app/redis":         3,
+		"/app/redis/address": 14,
+		"/app/redis/db":      1,
+		"/app/redis/pool/":   1,
+		"/application":       1,
+	})
+	assert.Equal(t, []string{"name", "redis/"}, root.names())
+	assert.Equal(t, 4, root.keys)
+	assert.True(t, root.children["redis"].leaf)
+
+	buf := &bytes.Buffer{}
+	root.print(buf, "/app/", "", 0, true)
+	assert.Equal(t, `/app/ (4 keys, 22 B)
+├── name (4 B)
+└── redis/ (3 keys, 18 B)
+    ├── address (14 B)
+    └── db (1 B)
+`, buf.String())
+
+	buf.Reset()
+	root.print(buf, "/app/", "", 2, false)
+	assert.Equal(t, "/app/ (4 keys)\n├── name\n└── redis/ (3 keys)\n", buf.String())
+
+	assert.Equal(t, "1.5 KiB", formatBytes(1536))
+}
@@ -0,0 +1,117 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check configure files or a live dir against a json schema",
+	Long: `Validate the configure files given by -c, merged and interpolated as put
+does, or the dir at the dsn path when there is none. The schema is read from
+--schema, or from the ` + schemaKey + ` key under the dsn path.
+
+Example:
+  etcd-tool validate -e localhost:2379/my_project -c prod.json
+  etcd-tool validate -e localhost:2379/my_project --schema schema.json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := validateArg.Run(); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var validateArg ValidateArg
+
+type ValidateArg struct {
+	Dsn      string
+	Schema   string
+	Conf     []string
+	Format   string
+	NoInterp bool
+}
+
+func init() {
+	RootCmd.AddCommand(validateCmd)
+
+	validateCmd.Flags().StringVarP(&validateArg.Dsn, "etcd", "e", "", "etcd address")
+	validateCmd.Flags().StringVar(&validateArg.Schema, "schema", "", "json schema file, default is the "+schemaKey+" key under the path")
+	validateCmd.Flags().StringSliceVarP(&validateArg.Conf, "conf", "c", nil, "configure files or dirs to validate instead of the live dir")
+	validateCmd.Flags().StringVar(&validateArg.Format, "format", "", "format of the configure files, default by file extension")
+	validateCmd.Flags().BoolVar(&validateArg.NoInterp, "no-interpolate", false, "don't expand ${...} expressions")
+}
+
+func (v *ValidateArg) Run() error {
+	var cli *client.Client
+	var cfg *client.Config
+	if v.Dsn != "" {
+		if cfg = client.ParseDSN(v.Dsn); cfg == nil {
+			return errors.New("invalid params")
+		}
+		var err error
+		if cli, err = client.NewClient(v.Dsn); err != nil {
+			return err
+		}
+	} else if v.Schema == "" || len(v.Conf) == 0 {
+		return errors.New("invalid params, need -e unless both --schema and -c are given")
+	}
+
+	var doc map[string]interface{}
+	if len(v.Conf) > 0 {
+		confMap, err := loadConfs(v.Conf, v.Format, false)
+		if err != nil {
+			return err
+		}
+		if !v.NoInterp {
+			if _, err := interpolate(confMap, ""); err != nil {
+				return err
+			}
+		}
+		doc = docWithoutSchema(storedDoc(confMap))
+	} else {
+		kvs, err := cli.GetWithPrefix(cfg.Path)
+		if err != nil {
+			return err
+		}
+		doc = docWithoutSchema(buildTree(cfg.Path, kvs))
+	}
+
+	path := ""
+	if cfg != nil {
+		path = cfg.Path
+	}
+	schema, err := loadSchema(cli, v.Schema, path)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return errors.Errorf("no schema, give --schema or put one at %s", joinKey(path, schemaKey))
+	}
+	if err := validateDoc(schema, doc); err != nil {
+		return err
+	}
+	fmt.Println("valid")
+	return nil
+}
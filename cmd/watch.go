@@ -0,0 +1,179 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Guazi-inc/etcd-tool/client"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	"github.com/go-errors/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch [path]",
+	Short: "print the changes under a path as they happen",
+	Long: `Stream the events under the dsn path, optionally narrowed by [path], one
+per line. The stream reconnects by itself and resumes after the last printed
+revision. etcd keeps no timestamps, the time of an event is when it was
+received.
+
+Example:
+  etcd-tool watch -e localhost:2379/my_project /redis --filter '*address*' --json`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := watchArg.Run(args); err != nil {
+			logrus.Errorf("got err: %s\n", err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+var watchArg WatchArg
+
+type WatchArg struct {
+	Dsn          string
+	FromRevision int64
+	Filter       []string
+	Json         bool
+}
+
+type WatchEvent struct {
+	Type      string    `json:"type"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value,omitempty"`
+	PrevValue *string   `json:"prev_value,omitempty"`
+	Revision  int64     `json:"revision"`
+	Time      time.Time `json:"time"`
+}
+
+func init() {
+	RootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().StringVarP(&watchArg.Dsn, "etcd", "e", "", "etcd address")
+	watchCmd.Flags().Int64Var(&watchArg.FromRevision, "from-revision", 0, "replay the events since this revision first")
+	watchCmd.Flags().StringSliceVar(&watchArg.Filter, "filter", nil, "only print keys matching a glob, relative to the path")
+	watchCmd.Flags().BoolVar(&watchArg.Json, "json", false, "print json lines")
+}
+
+func (w *WatchArg) Run(args []string) error {
+	cfg := client.ParseDSN(w.Dsn)
+	if cfg == nil || len(args) > 1 {
+		return errors.New("invalid params")
+	}
+	prefix := strings.TrimSuffix(cfg.Path, delimiter)
+	if len(args) == 1 {
+		prefix = joinKey(cfg.Path, args[0])
+	}
+	for _, pattern := range w.Filter {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Errorf("invalid filter pattern %s: %s", pattern, err.Error())
+		}
+	}
+
+	cli, err := client.NewClient(w.Dsn)
+	if err != nil {
+		return err
+	}
+	rev := w.FromRevision - 1
+	if w.FromRevision == 0 {
+		if rev, err = cli.Revision(); err != nil {
+			return err
+		}
+	}
+	for {
+		rev, err = w.stream(cli, prefix, rev, os.Stdout)
+		if err != nil {
+			logrus.Errorf("watch got err: %s, reconnect", err.Error())
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// stream prints the events under prefix after rev until the watch fails, and
+// returns the last printed revision. When rev has been compacted it resumes
+// from the oldest revision left.
+func (w *WatchArg) stream(cli *client.Client, prefix string, rev int64, out io.Writer) (int64, error) {
+	ctx, cancel := context.WithCancel(clientv3.WithRequireLeader(context.Background()))
+	defer cancel()
+	wc := cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(rev+1))
+	for wresp := range wc {
+		if wresp.Err() == rpctypes.ErrCompacted {
+			logrus.Warnf("revisions %d to %d have been compacted, resume from %d", rev+1, wresp.CompactRevision-1, wresp.CompactRevision)
+			return wresp.CompactRevision - 1, nil
+		}
+		if err := wresp.Err(); err != nil {
+			return rev, err
+		}
+		now := time.Now()
+		for _, ev := range wresp.Events {
+			rev = ev.Kv.ModRevision
+			k := string(ev.Kv.Key)
+			if k != prefix && !strings.HasPrefix(k, prefix+delimiter) || isReserved(k) && !isReserved(prefix) {
+				continue
+			}
+			if len(w.Filter) > 0 && !matchGlob(w.Filter, strings.TrimPrefix(k, prefix)) {
+				continue
+			}
+			e := newWatchEvent(ev, now)
+			if w.Json {
+				buf, err := marshalCompact(e)
+				if err != nil {
+					return rev, err
+				}
+				fmt.Fprintln(out, string(buf))
+			} else {
+				fmt.Fprintln(out, e.String())
+			}
+		}
+	}
+	return rev, errors.New("watch closed")
+}
+
+func newWatchEvent(ev *clientv3.Event, t time.Time) WatchEvent {
+	e := WatchEvent{
+		Type:     ev.Type.String(),
+		Key:      string(ev.Kv.Key),
+		Value:    string(ev.Kv.Value),
+		Revision: ev.Kv.ModRevision,
+		Time:     t,
+	}
+	if ev.PrevKv != nil {
+		prev := string(ev.PrevKv.Value)
+		e.PrevValue = &prev
+	}
+	return e
+}
+
+// String formats the event as "<time> <revision> <type> <key> = <value> (was <prev value>)"
+func (e WatchEvent) String() string {
+	s := fmt.Sprintf("%s %d %s %s", e.Time.Format(time.RFC3339), e.Revision, e.Type, e.Key)
+	if e.Type != "DELETE" {
+		s += " = " + e.Value
+	}
+	if e.PrevValue != nil {
+		s += " (was " + *e.PrevValue + ")"
+	}
+	return s
+}
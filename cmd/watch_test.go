@@ -0,0 +1,17 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchEvent_String(t *testing.T) {
+	now := time.Date(2018, 10, 24, 10, 0, 0, 0, time.UTC)
+	prev := "6379"
+	assert.Equal(t, "2018-10-24T10:00:00Z 12 PUT /redis/port = 6380 (was 6379)",
+		WatchEvent{Type: "PUT", Key: "/redis/port", Value: "6380", PrevValue: &prev, Revision: 12, Time: now}.String())
+	assert.Equal(t, "2018-10-24T10:00:00Z 13 DELETE /redis/port (was 6379)",
+		WatchEvent{Type: "DELETE", Key: "/redis/port", PrevValue: &prev, Revision: 13, Time: now}.String())
+}
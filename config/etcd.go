@@ -10,6 +10,7 @@ import (
 
 	"github.com/Guazi-inc/etcd-tool/client"
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/json-iterator/go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -27,6 +28,8 @@ var (
 	ErrConfigPtToPtr  = errors.New("Invalid parameter: 'config' can't point to a pointer")
 	ErrConfigNilPtr   = errors.New("Invalid parameter: 'config' is a nil pointer")
 	ErrUnknowResult   = errors.New("unknow result type")
+	//key不存在，区别于值为空的key
+	ErrKeyNotFound = client.ErrKeyNotFound
 )
 
 var (
@@ -82,7 +85,16 @@ func SetNamespace(path string) {
  * config: pointer of config struct
  */
 func Get(key string, config interface{}) error {
-	return get(key, config)
+	return get(key, config, false)
+}
+
+/* GetExisting 获取配置，与Get不同的是key不存在时返回ErrKeyNotFound
+ * Get对不存在的key返回nil并保持config原值，值为空的key则是存在的
+ * key: etcd中的完整路径
+ * config: pointer of config struct
+ */
+func GetExisting(key string, config interface{}) error {
+	return get(key, config, true)
 }
 
 /* GetInNamespace 在某个namespace下获取配置
@@ -110,10 +122,11 @@ func GetInNamespace(key string, config interface{}, namespaceLevel int) error {
 	if namespaceLevel > 0 {
 		key = fmt.Sprintf("%s%s%s", delimiter, strings.Join(globalNamespaceList[:namespaceLevel], delimiter), key)
 	}
-	return get(key, config)
+	return get(key, config, false)
 }
 
-func get(key string, config interface{}) (errRet error) {
+// strict为true时key不存在返回ErrKeyNotFound
+func get(key string, config interface{}, strict bool) (errRet error) {
 	defer func() {
 		logrus.Infof("ETCD - get config with key: %s, Err: %+v", key, errRet)
 	}()
@@ -145,20 +158,33 @@ func get(key string, config interface{}) (errRet error) {
 		//若with prefix为空，尝试unmarshal key上的值
 		if len(result) == 0 {
 			val, err := getValWithCache(key)
+			if err != nil && err != ErrKeyNotFound {
+				return err
+			}
 			if err == nil && val != "" {
 				if err := jsoniter.Unmarshal([]byte(val), config); err == nil {
 					return nil
 				}
 			}
+			if err == ErrKeyNotFound && strict {
+				return err
+			}
 			return ErrKvsEmpty
 		}
 		return fillConfig(result, ct, cv)
 	default:
 		val, err := getValWithCache(key)
+		if err == ErrKeyNotFound && !strict {
+			return nil
+		}
 		if err != nil {
 			return err
 		}
+		//空值是有意设置的，字符串置空，其他类型保持原值
 		if val == "" {
+			if ct.Kind() == reflect.String {
+				cv.SetString("")
+			}
 			return nil
 		}
 		err = jsoniter.Unmarshal([]byte(val), config)
@@ -187,18 +213,17 @@ func formatKey(key string) string {
 	return key
 }
 
+// key不存在时返回ErrKeyNotFound，存在的key包括空值都会缓存
 func getValWithCache(key string) (string, error) {
 	if v, ok := kvCache.Load(key); ok {
 		return v.(string), nil
 	}
-	val, err := etcdClient.Get(key)
+	kv, err := etcdClient.GetKV(key)
 	if err != nil {
 		return "", err
 	}
-	if val != "" {
-		kvCache.Store(key, val)
-	}
-	return val, nil
+	kvCache.Store(key, kv.Value)
+	return kv.Value, nil
 }
 
 func getKvsMapWithCache(key string) (map[string]interface{}, error) {
@@ -219,7 +244,8 @@ func getKvsMap(key string) (map[string]interface{}, error) {
 	if !strings.HasSuffix(key, delimiter) {
 		key = key + delimiter
 	}
-	kvs, err := etcdClient.GetWithPrefix(key)
+	//分页读取，大的前缀也不会超过单次响应的大小限制
+	kvs, _, err := etcdClient.GetWithPrefixPaged(context.Background(), key, 0)
 	if err != nil || len(kvs) == 0 {
 		return nil, err
 	}
@@ -347,7 +373,9 @@ func watch() {
 			if !isValidKey(string(ev.Kv.Key)) {
 				continue
 			}
-			if _, ok := kvCache.Load(string(ev.Kv.Key)); ok {
+			if ev.Type == mvccpb.DELETE {
+				kvCache.Delete(string(ev.Kv.Key))
+			} else if _, ok := kvCache.Load(string(ev.Kv.Key)); ok {
 				kvCache.Store(string(ev.Kv.Key), string(ev.Kv.Value))
 			}
 
@@ -401,9 +429,12 @@ func runWatchFuncs(key string) {
 }
 
 func CheckKeys(keys, keysWithPrefix []string) {
+	//空值的key是有意设置的，只检查key是否存在
 	for _, k := range keys {
-		if v, err := etcdClient.Get(k); err != nil || v == "" {
-			panic(fmt.Sprintf("empty key: %s, Err: %+v", k, err))
+		if _, err := etcdClient.GetKV(k); err == ErrKeyNotFound {
+			panic(fmt.Sprintf("missing key: %s", k))
+		} else if err != nil {
+			panic(fmt.Sprintf("failed to check key: %s, Err: %+v", k, err))
 		}
 	}
 
@@ -179,6 +179,39 @@ func TestGet2(t *testing.T) {
 
 }
 
+func TestGetEmptyAndMissing(t *testing.T) {
+	key := "/wby/test_empty"
+	etcdClient.Delete(key)
+	var missing = "default"
+	err := Get(key, &missing)
+	assert.Nil(t, err)
+	assert.Equal(t, missing, "default")
+	err = GetExisting(key, &missing)
+	assert.Equal(t, err, ErrKeyNotFound)
+	assert.Equal(t, missing, "default")
+	var missingMap map[string]string
+	assert.Equal(t, Get(key, &missingMap), ErrKvsEmpty)
+	assert.Equal(t, GetExisting(key, &missingMap), ErrKeyNotFound)
+
+	etcdClient.Put(key, "")
+	time.Sleep(1000 * time.Millisecond)
+	var empty = "default"
+	err = Get(key, &empty)
+	assert.Nil(t, err)
+	assert.Equal(t, empty, "")
+	empty = "default"
+	err = GetExisting(key, &empty)
+	assert.Nil(t, err)
+	assert.Equal(t, empty, "")
+	assert.NotPanics(t, func() { CheckKeys([]string{key}, nil) })
+
+	etcdClient.Delete(key)
+	time.Sleep(1000 * time.Millisecond)
+	assert.Panics(t, func() { CheckKeys([]string{key}, nil) })
+	err = GetExisting(key, &empty)
+	assert.Equal(t, err, ErrKeyNotFound)
+}
+
 func TestGet3(t *testing.T) {
 	cfg := client.ParseDSN("@localhost:2379")
 	t.Log(cfg)